@@ -7,11 +7,16 @@ import (
 	"github.com/joho/godotenv"
 )
 
+const defaultMongoURI = "mongodb://localhost:27017"
+
 func EnvMongoURI() string {
-	err := godotenv.Load()
-	if err != nil {
-		log.Fatal("Error loading .env file")
+	// the variables may also come from the environment, e.g. in containers and tests
+	if err := godotenv.Load(); err != nil {
+		log.Println("No .env file loaded")
 	}
 
-	return os.Getenv("MONGOURI")
+	if uri := os.Getenv("MONGOURI"); uri != "" {
+		return uri
+	}
+	return defaultMongoURI
 }
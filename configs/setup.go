@@ -10,25 +10,26 @@ import (
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// ConnectDB creates the client, the servers are only dialed on first use so
+// packages using the collections can be loaded without a database
 func ConnectDB() *mongo.Client {
-	client, err := mongo.NewClient(options.Client().ApplyURI(EnvMongoURI()))
+	client, err := mongo.Connect(context.Background(), options.Client().ApplyURI(EnvMongoURI()))
 	if err != nil {
 		log.Fatal(err)
 	}
+	return client
+}
 
-	ctx, _ := context.WithTimeout(context.Background(), 10*time.Second)
-	err = client.Connect(ctx)
-	if err != nil {
-		log.Fatal(err)
-	}
+// PingDB checks that the database is reachable, main calls it before serving
+func PingDB() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
 
-	//ping the database
-	err = client.Ping(ctx, nil)
-	if err != nil {
-		log.Fatal(err)
+	if err := DB.Ping(ctx, nil); err != nil {
+		return err
 	}
 	fmt.Println("Connected to MongoDB")
-	return client
+	return nil
 }
 
 // Client instance
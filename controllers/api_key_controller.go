@@ -0,0 +1,102 @@
+package controllers
+
+import (
+	"context"
+	"gin-api/configs"
+	"gin-api/helpers"
+	"gin-api/models"
+	"gin-api/responses"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var apiKeyCollection *mongo.Collection = configs.GetCollection(configs.DB, "api_keys")
+
+// CreateApiKey issues an API key, the key itself is only returned here
+func CreateApiKey(c *gin.Context) {
+	var request models.CreateApiKeyRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		responses.Validation(c, err)
+		return
+	}
+
+	if request.Expires_at != nil && request.Expires_at.Before(time.Now()) {
+		responses.Error(c, responses.InvalidRequest, "Expiry must be in the future")
+		return
+	}
+
+	key, prefix, hash, err := helpers.NewApiKey()
+	if err != nil {
+		responses.Error(c, responses.InternalError, "Error creating API key")
+		return
+	}
+
+	apiKey := models.ApiKey{
+		ID:          uuid.New().String(),
+		Name:        request.Name,
+		Prefix:      prefix,
+		Hash:        hash,
+		Scopes:      request.Scopes,
+		Allowed_ips: request.Allowed_ips,
+		Expires_at:  request.Expires_at,
+		Created_by:  currentUserID(c),
+		Created_at:  time.Now(),
+	}
+	if _, err := apiKeyCollection.InsertOne(context.Background(), apiKey); err != nil {
+		responses.Error(c, responses.InternalError, "Error creating API key")
+		return
+	}
+
+	responses.Success(c, http.StatusCreated, "API key created, store the key now as it is not shown again", gin.H{
+		"key":     key,
+		"api_key": apiKey,
+	})
+}
+
+// ListApiKeys returns every API key without the secrets
+func ListApiKeys(c *gin.Context) {
+	opts := options.Find().SetSort(bson.M{"created_at": -1})
+	cur, err := apiKeyCollection.Find(context.Background(), bson.D{}, opts)
+	if err != nil {
+		responses.Error(c, responses.InternalError, "Error fetching API keys")
+		return
+	}
+	defer cur.Close(context.Background())
+
+	apiKeys := []models.ApiKey{}
+	if err := cur.All(context.Background(), &apiKeys); err != nil {
+		responses.Error(c, responses.InternalError, "Error decoding API keys")
+		return
+	}
+
+	responses.Success(c, http.StatusOK, "Get API Keys", apiKeys)
+}
+
+// RevokeApiKey stops the key from being accepted, the record stays for reference
+func RevokeApiKey(c *gin.Context) {
+	id, ok := bindID(c)
+	if !ok {
+		return
+	}
+
+	filter := bson.M{"_id": id, "revoked_at": bson.M{"$exists": false}}
+	update := bson.M{"$set": bson.M{"revoked_at": time.Now()}}
+
+	result, err := apiKeyCollection.UpdateOne(context.Background(), filter, update)
+	if err != nil {
+		responses.Error(c, responses.InternalError, "Error revoking API key")
+		return
+	}
+	if result.MatchedCount == 0 {
+		responses.Error(c, responses.ApiKeyNotFound, "API key not found")
+		return
+	}
+
+	responses.Success(c, http.StatusOK, "API key revoked", nil)
+}
@@ -0,0 +1,270 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"gin-api/configs"
+	"gin-api/helpers"
+	"gin-api/models"
+	"gin-api/responses"
+	"log"
+	"net/http"
+	"reflect"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// append only, nothing updates or deletes audit entries
+var auditCollection *mongo.Collection = configs.GetCollection(configs.DB, "audit_log")
+
+// audited entity types
+const (
+	entityProduct  = "product"
+	entityCategori = "categori"
+	entityUser     = "user"
+	entityRole     = "role"
+)
+
+// fields whose values never end up in the audit log, a change is still recorded
+var redactedFields = map[string]bool{
+	"password":           true,
+	"mfa_secret":         true,
+	"mfa_pending_secret": true,
+	"mfa_recovery_codes": true,
+}
+
+const redacted = "[redacted]"
+
+// toDocument converts a model or a decoded document to a document keyed by stored field names
+func toDocument(v interface{}) bson.M {
+	if v == nil || reflect.ValueOf(v).Kind() == reflect.Ptr && reflect.ValueOf(v).IsNil() {
+		return nil
+	}
+	if doc, ok := v.(bson.M); ok {
+		return doc
+	}
+
+	data, err := bson.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	var doc bson.M
+	if err := bson.Unmarshal(data, &doc); err != nil {
+		return nil
+	}
+	return doc
+}
+
+// diffDocuments returns the fields that differ between before and after
+func diffDocuments(before, after bson.M) map[string]models.AuditChange {
+	changes := map[string]models.AuditChange{}
+	for key, value := range before {
+		if other, ok := after[key]; !ok || !reflect.DeepEqual(value, other) {
+			changes[key] = models.AuditChange{Before: value, After: after[key]}
+		}
+	}
+	for key, value := range after {
+		if _, ok := before[key]; !ok {
+			changes[key] = models.AuditChange{After: value}
+		}
+	}
+	return changes
+}
+
+// auditChanges returns the fields that differ between before and after, secrets redacted
+func auditChanges(before, after bson.M) map[string]models.AuditChange {
+	changes := diffDocuments(before, after)
+	delete(changes, "_id")
+	for key, change := range changes {
+		if !redactedFields[key] {
+			continue
+		}
+		if change.Before != nil {
+			change.Before = redacted
+		}
+		if change.After != nil {
+			change.After = redacted
+		}
+		changes[key] = change
+	}
+	return changes
+}
+
+func insertAudit(ctx context.Context, entry models.AuditEntry, before, after interface{}) {
+	entry.ID = uuid.New().String()
+	entry.Changes = auditChanges(toDocument(before), toDocument(after))
+	entry.Created_at = time.Now()
+
+	// the change is already stored, a failure here must not fail the request
+	if _, err := auditCollection.InsertOne(ctx, entry); err != nil {
+		log.Printf("recording audit entry %s %s %s: %v", entry.Action, entry.Entity_type, entry.Entity_id, err)
+	}
+}
+
+// auditActor returns an entry attributed to the logged in user or API key of the request
+func auditActor(c *gin.Context) models.AuditEntry {
+	entry := models.AuditEntry{
+		Request_id: c.GetString("requestID"),
+		IP:         c.ClientIP(),
+	}
+	if claims, ok := c.Get("userLogin"); ok {
+		if details, ok := claims.(*helpers.SignedDetails); ok {
+			entry.Actor_id = details.UserID
+			entry.Actor_role = details.RoleType
+		}
+	}
+	return entry
+}
+
+// recordAudit stores a change made by the logged in user or API key of the
+// request. before and after are models or documents, nil when absent.
+func recordAudit(c *gin.Context, action, entityType, entityID string, before, after interface{}) {
+	entry := auditActor(c)
+	entry.Action = action
+	entry.Entity_type = entityType
+	entry.Entity_id = entityID
+
+	insertAudit(c.Request.Context(), entry, before, after)
+}
+
+// findAndUpdateAttempts bounds the reads of findAndUpdate while other writes keep changing the document
+const findAndUpdateAttempts = 5
+
+var errWriteContention = errors.New("document kept changing during the update")
+
+// findAndUpdate applies update to the document matching filter and returns
+// the document before and after the update, for the audit log. The updated
+// document is also decoded into result unless it is nil.
+//
+// The update only applies while the document is still the one read first, so
+// before is exactly the document it replaced. When another write came in
+// between, the document is read again.
+func findAndUpdate(ctx context.Context, collection *mongo.Collection, filter, update bson.M, result interface{}) (before, after bson.M, err error) {
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	for attempt := 0; attempt < findAndUpdateAttempts; attempt++ {
+		var current bson.Raw
+		if err := collection.FindOne(ctx, filter).Decode(&current); err != nil {
+			return nil, nil, err
+		}
+
+		unchanged := bson.M{
+			"_id":   current.Lookup("_id"),
+			"$expr": bson.M{"$eq": bson.A{"$$ROOT", bson.M{"$literal": current}}},
+		}
+		var updated bson.Raw
+		err := collection.FindOneAndUpdate(ctx, bson.M{"$and": bson.A{filter, unchanged}}, update, opts).Decode(&updated)
+		if err == mongo.ErrNoDocuments {
+			continue
+		} else if err != nil {
+			return nil, nil, err
+		}
+
+		if result != nil {
+			if err := bson.Unmarshal(updated, result); err != nil {
+				return nil, nil, err
+			}
+		}
+		if err := bson.Unmarshal(current, &before); err != nil {
+			return nil, nil, err
+		}
+		return before, after, bson.Unmarshal(updated, &after)
+	}
+	return nil, nil, errWriteContention
+}
+
+func auditFilter(query models.AuditFilter) bson.M {
+	filter := bson.M{}
+	if query.Entity_type != "" {
+		filter["entity_type"] = query.Entity_type
+	}
+	if query.Entity_id != "" {
+		filter["entity_id"] = query.Entity_id
+	}
+	if query.Actor_id != "" {
+		filter["actor_id"] = query.Actor_id
+	}
+	if query.Action != "" {
+		filter["action"] = query.Action
+	}
+
+	createdAt := bson.M{}
+	if !query.From.IsZero() {
+		createdAt["$gte"] = query.From
+	}
+	if !query.To.IsZero() {
+		createdAt["$lt"] = query.To
+	}
+	if len(createdAt) > 0 {
+		filter["created_at"] = createdAt
+	}
+	return filter
+}
+
+// ListAudit returns the latest audit entries, filtered by entity, actor, action or time
+func ListAudit(c *gin.Context) {
+	query := models.AuditQuery{Limit: 100}
+	if err := c.ShouldBindQuery(&query); err != nil {
+		responses.Validation(c, err)
+		return
+	}
+
+	ctx := c.Request.Context()
+	opts := options.Find().SetSort(bson.M{"created_at": -1}).SetLimit(query.Limit)
+	cur, err := auditCollection.Find(ctx, auditFilter(query.AuditFilter), opts)
+	if err != nil {
+		responses.Error(c, responses.InternalError, "Error fetching audit log")
+		return
+	}
+	defer cur.Close(ctx)
+
+	entries := []models.AuditEntry{}
+	if err := cur.All(ctx, &entries); err != nil {
+		responses.Error(c, responses.InternalError, "Error decoding audit log")
+		return
+	}
+
+	responses.Success(c, http.StatusOK, "Get Audit Log", entries)
+}
+
+// ExportAudit streams every matching audit entry as JSON lines, oldest first
+func ExportAudit(c *gin.Context) {
+	var query models.AuditFilter
+	if err := c.ShouldBindQuery(&query); err != nil {
+		responses.Validation(c, err)
+		return
+	}
+
+	ctx := c.Request.Context()
+	opts := options.Find().SetSort(bson.M{"created_at": 1})
+	cur, err := auditCollection.Find(ctx, auditFilter(query), opts)
+	if err != nil {
+		responses.Error(c, responses.InternalError, "Error fetching audit log")
+		return
+	}
+	defer cur.Close(ctx)
+
+	c.Header("Content-Type", "application/x-ndjson")
+	c.Header("Content-Disposition", `attachment; filename="audit-log.ndjson"`)
+	c.Status(http.StatusOK)
+
+	encoder := json.NewEncoder(c.Writer)
+	for cur.Next(ctx) {
+		var entry models.AuditEntry
+		if err := cur.Decode(&entry); err != nil {
+			log.Printf("exporting audit log: %v", err)
+			return
+		}
+		if err := encoder.Encode(entry); err != nil {
+			return
+		}
+	}
+	if err := cur.Err(); err != nil {
+		log.Printf("exporting audit log: %v", err)
+	}
+}
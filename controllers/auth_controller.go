@@ -0,0 +1,299 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"gin-api/configs"
+	"gin-api/helpers"
+	"gin-api/models"
+	"gin-api/responses"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var tokenCollection *mongo.Collection = configs.GetCollection(configs.DB, "user_tokens")
+var mailer helpers.Mailer = helpers.NewMailer()
+
+var errInvalidUserToken = errors.New("invalid or expired token")
+
+const (
+	verificationExpiration = 24 * time.Hour
+	// verification emails can be resent once a minute, five times a day
+	resendInterval   = time.Minute
+	resendDailyLimit = 5
+)
+
+// issueUserToken stores the hash of a new token and returns the token to send
+func issueUserToken(ctx context.Context, userID, purpose string, expiration time.Duration) (string, error) {
+	token, hash, err := helpers.NewOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+
+	userToken := models.UserToken{
+		ID:         hash,
+		User_id:    userID,
+		Purpose:    purpose,
+		Expires_at: time.Now().Add(expiration),
+		Created_at: time.Now(),
+	}
+	if _, err := tokenCollection.InsertOne(ctx, userToken); err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// userTokenFilter matches the unexpired token of the purpose
+func userTokenFilter(token, purpose string) bson.M {
+	return bson.M{
+		"_id":        helpers.HashOpaqueToken(token),
+		"purpose":    purpose,
+		"expires_at": bson.M{"$gt": time.Now()},
+	}
+}
+
+// userTokenOwner returns the user of a token without using it up, or errInvalidUserToken
+func userTokenOwner(ctx context.Context, token, purpose string) (models.User, error) {
+	var userToken models.UserToken
+	var user models.User
+	err := tokenCollection.FindOne(ctx, userTokenFilter(token, purpose)).Decode(&userToken)
+	if err == mongo.ErrNoDocuments {
+		return user, errInvalidUserToken
+	} else if err != nil {
+		return user, err
+	}
+
+	err = userCollection.FindOne(ctx, bson.M{"_id": userToken.User_id}).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		return user, errInvalidUserToken
+	}
+	return user, err
+}
+
+// consumeUserToken deletes the token and returns its user, so that it can be used once
+func consumeUserToken(ctx context.Context, token, purpose string) (string, error) {
+	var userToken models.UserToken
+	err := tokenCollection.FindOneAndDelete(ctx, userTokenFilter(token, purpose)).Decode(&userToken)
+	if err == mongo.ErrNoDocuments {
+		return "", errInvalidUserToken
+	} else if err != nil {
+		return "", err
+	}
+
+	return userToken.User_id, nil
+}
+
+// canResend applies the resend limits to the tokens of the user
+func canResend(ctx context.Context, userID, purpose string) (bool, error) {
+	filter := bson.M{
+		"user_id":    userID,
+		"purpose":    purpose,
+		"created_at": bson.M{"$gt": time.Now().Add(-24 * time.Hour)},
+	}
+
+	var latest models.UserToken
+	opts := options.FindOne().SetSort(bson.M{"created_at": -1})
+	err := tokenCollection.FindOne(ctx, filter, opts).Decode(&latest)
+	if err == mongo.ErrNoDocuments {
+		return true, nil
+	} else if err != nil {
+		return false, err
+	}
+	if time.Since(latest.Created_at) < resendInterval {
+		return false, nil
+	}
+
+	count, err := tokenCollection.CountDocuments(ctx, filter)
+	if err != nil {
+		return false, err
+	}
+	return count < resendDailyLimit, nil
+}
+
+func sendVerificationEmail(ctx context.Context, user models.User) error {
+	token, err := issueUserToken(ctx, user.ID, models.TokenVerifyEmail, verificationExpiration)
+	if err != nil {
+		return err
+	}
+
+	link := helpers.AppURL() + "/api/auth/verify?token=" + url.QueryEscape(token)
+	body := fmt.Sprintf("Hi %s,\n\nPlease verify your email address by opening the link below within 24 hours:\n\n%s\n", user.Username, link)
+
+	return mailer.Send(ctx, user.Email, "Verify your email address", body)
+}
+
+// defaultRoleID returns the role of self registered users, set by DEFAULT_ROLE
+func defaultRoleID(ctx context.Context) (string, error) {
+	name := os.Getenv("DEFAULT_ROLE")
+	if name == "" {
+		name = "customer"
+	}
+
+	var role models.Role
+	if err := roleCollection.FindOne(ctx, bson.M{"name": name}).Decode(&role); err != nil {
+		return "", err
+	}
+	return role.ID, nil
+}
+
+// Register creates an unverified account with the default role and emails the verification link
+func Register(c *gin.Context) {
+	var request models.RegisterRequest
+	if err := c.ShouldBind(&request); err != nil {
+		responses.Validation(c, err)
+		return
+	}
+
+	ctx := c.Request.Context()
+	taken, err := userCollection.CountDocuments(ctx, bson.M{"$or": []bson.M{
+		{"username": request.Username},
+		{"email": request.Email},
+	}})
+	if err != nil {
+		responses.Error(c, responses.InternalError, "Error creating user")
+		return
+	}
+	if taken > 0 {
+		responses.Error(c, responses.UserExists, "Username or email already registered")
+		return
+	}
+
+	if err := helpers.ValidatePassword(request.Password, request.Username, request.Email); err != nil {
+		responses.Error(c, responses.PasswordPolicy, err.Error())
+		return
+	}
+
+	password, err := HashPassword(request.Password)
+	if err != nil {
+		responses.Error(c, responses.InternalError, "Error creating user")
+		return
+	}
+
+	roleID, err := defaultRoleID(ctx)
+	if err != nil {
+		responses.Error(c, responses.InternalError, "Default role not found")
+		return
+	}
+
+	user := models.User{
+		ID:                  uuid.New().String(),
+		Username:            request.Username,
+		Password:            password,
+		Name:                request.Name,
+		Email:               request.Email,
+		Role_id:             roleID,
+		PendingVerification: true,
+		Version:             1,
+		Created_at:          time.Now(),
+		Updated_at:          time.Now(),
+	}
+
+	// Upload the optional avatar
+	if request.Image != nil {
+		avatar, err := helpers.UploadAvatar(user.ID, request.Image)
+		if err != nil {
+			avatarError(c, err)
+			return
+		}
+		user.Image = avatar
+	}
+
+	if _, err := userCollection.InsertOne(ctx, user); err != nil {
+		responses.Error(c, responses.InternalError, "Error creating user")
+		return
+	}
+
+	if err := sendVerificationEmail(ctx, user); err != nil {
+		// the user can ask for a new link
+		log.Printf("sending verification email to %s: %v", user.Email, err)
+	}
+
+	responses.Success(c, http.StatusCreated, "User registered, check your email to verify the account", gin.H{
+		"id":         user.ID,
+		"username":   user.Username,
+		"email":      user.Email,
+		"name":       user.Name,
+		"avatar_url": helpers.AvatarURL(user.Image),
+	})
+}
+
+// VerifyEmail activates the account of the emailed token
+func VerifyEmail(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	userID, err := consumeUserToken(ctx, c.Query("token"), models.TokenVerifyEmail)
+	if err == errInvalidUserToken {
+		responses.Error(c, responses.InvalidToken, "Invalid or expired verification link")
+		return
+	} else if err != nil {
+		responses.Error(c, responses.InternalError, "Error verifying email")
+		return
+	}
+
+	now := time.Now()
+	update := bson.M{
+		"$unset": bson.M{"pending_verification": ""},
+		"$set":   bson.M{"verified_at": now, "updated_at": now},
+	}
+	if _, err := userCollection.UpdateOne(ctx, bson.M{"_id": userID}, update); err != nil {
+		responses.Error(c, responses.InternalError, "Error verifying email")
+		return
+	}
+
+	// older links of the user are no longer needed
+	tokenCollection.DeleteMany(ctx, bson.M{"user_id": userID, "purpose": models.TokenVerifyEmail})
+
+	responses.Success(c, http.StatusOK, "Email verified", nil)
+}
+
+// ResendVerification sends a new verification link. The response is the same
+// whether or not the email belongs to an unverified account.
+func ResendVerification(c *gin.Context) {
+	var request models.ResendVerificationRequest
+	if err := c.ShouldBind(&request); err != nil {
+		responses.Validation(c, err)
+		return
+	}
+
+	ctx := c.Request.Context()
+	const sent = "If the account exists and is not verified, a verification email has been sent"
+
+	var user models.User
+	filter := bson.M{"email": request.Email, "pending_verification": true}
+	err := userCollection.FindOne(ctx, filter).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		responses.Success(c, http.StatusOK, sent, nil)
+		return
+	} else if err != nil {
+		responses.Error(c, responses.InternalError, "Error sending verification email")
+		return
+	}
+
+	allowed, err := canResend(ctx, user.ID, models.TokenVerifyEmail)
+	if err != nil {
+		responses.Error(c, responses.InternalError, "Error sending verification email")
+		return
+	}
+	if !allowed {
+		responses.Error(c, responses.TooManyRequests, "Too many verification emails, try again later")
+		return
+	}
+
+	if err := sendVerificationEmail(ctx, user); err != nil {
+		responses.Error(c, responses.InternalError, "Error sending verification email")
+		return
+	}
+
+	responses.Success(c, http.StatusOK, sent, nil)
+}
@@ -0,0 +1,127 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"gin-api/helpers"
+	"gin-api/models"
+	"gin-api/responses"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// currentUserID returns the id of the token set by the auth middleware
+func currentUserID(c *gin.Context) string {
+	claims, _ := c.Get("userLogin")
+	details, ok := claims.(*helpers.SignedDetails)
+	if !ok {
+		return ""
+	}
+	return details.UserID
+}
+
+// viewerAvatarURL signs the avatar of the user for the owner or an admin, other viewers get ""
+func viewerAvatarURL(c *gin.Context, user models.User) string {
+	claims, _ := c.Get("userLogin")
+	details, _ := claims.(*helpers.SignedDetails)
+	if user.Image == nil || !helpers.CanAccessAsset(details, user.Image.Filename) {
+		return ""
+	}
+	return helpers.AvatarURL(user.Image)
+}
+
+// avatarError writes the response for a failed avatar upload
+func avatarError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, helpers.ErrInvalidImage):
+		responses.Error(c, responses.InvalidImage, "Avatar must be a PNG or JPEG image")
+	case errors.Is(err, helpers.ErrFileTooLarge):
+		responses.Error(c, responses.FileTooLarge, "File too large")
+	default:
+		responses.Error(c, responses.InternalError, "Error uploading avatar")
+	}
+}
+
+// UpdateMyAvatar replaces the avatar of the logged in user
+func UpdateMyAvatar(c *gin.Context) {
+	userID := currentUserID(c)
+
+	var request models.AvatarRequest
+	if err := c.ShouldBind(&request); err != nil {
+		responses.Validation(c, err)
+		return
+	}
+	image := request.Image
+
+	oldImage, err := storedImage(context.Background(), userCollection, userID)
+	if err == mongo.ErrNoDocuments {
+		responses.Error(c, responses.UserNotFound, "User not found")
+		return
+	} else if err != nil {
+		responses.Error(c, responses.InternalError, "Error fetching user")
+		return
+	}
+
+	avatar, err := helpers.UploadAvatar(userID, image)
+	if err != nil {
+		avatarError(c, err)
+		return
+	}
+
+	update := bson.M{"$set": bson.M{"image": avatar, "updated_at": time.Now()}, "$inc": bson.M{"version": 1}}
+	before, after, err := findAndUpdate(context.Background(), userCollection, bson.M{"_id": userID}, update, nil)
+	if err == mongo.ErrNoDocuments {
+		removeImageIfOrphaned(context.Background(), avatar.Filename)
+		responses.Error(c, responses.UserNotFound, "User not found")
+		return
+	} else if err != nil {
+		responses.Error(c, responses.InternalError, "Error updating user")
+		return
+	}
+	recordAudit(c, models.AuditUpdate, entityUser, userID, before, after)
+
+	if oldImage != avatar.Filename {
+		removeImageIfOrphaned(context.Background(), oldImage)
+	}
+
+	responses.Success(c, http.StatusOK, "Avatar updated", gin.H{
+		"avatar_url": helpers.AvatarURL(avatar),
+	})
+}
+
+// DeleteMyAvatar removes the avatar of the logged in user
+func DeleteMyAvatar(c *gin.Context) {
+	userID := currentUserID(c)
+
+	oldImage, err := storedImage(context.Background(), userCollection, userID)
+	if err == mongo.ErrNoDocuments {
+		responses.Error(c, responses.UserNotFound, "User not found")
+		return
+	} else if err != nil {
+		responses.Error(c, responses.InternalError, "Error fetching user")
+		return
+	}
+
+	update := bson.M{
+		"$unset": bson.M{"image": ""},
+		"$set":   bson.M{"updated_at": time.Now()},
+		"$inc":   bson.M{"version": 1},
+	}
+	before, after, err := findAndUpdate(context.Background(), userCollection, bson.M{"_id": userID}, update, nil)
+	if err == mongo.ErrNoDocuments {
+		responses.Error(c, responses.UserNotFound, "User not found")
+		return
+	} else if err != nil {
+		responses.Error(c, responses.InternalError, "Error updating user")
+		return
+	}
+	recordAudit(c, models.AuditUpdate, entityUser, userID, before, after)
+
+	removeImageIfOrphaned(context.Background(), oldImage)
+
+	responses.Success(c, http.StatusOK, "Avatar deleted", nil)
+}
@@ -0,0 +1,199 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"gin-api/helpers"
+	"gin-api/responses"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// objects younger than this are skipped by the reconciler, because an
+// upload happens before the document referencing it is inserted
+const defaultOrphanMinAge = time.Hour
+
+type reconcileReport struct {
+	DryRun  bool               `json:"dry_run"`
+	Scanned int                `json:"scanned"`
+	Orphans []helpers.BlobInfo `json:"orphans"`
+	Removed []string           `json:"removed"`
+	Failed  []string           `json:"failed"`
+}
+
+// imageReference is a collection and the field holding the object names of its images
+type imageReference struct {
+	collection *mongo.Collection
+	field      string
+}
+
+// every place an image is referenced, product revisions keep old images for rollbacks
+func imageReferences() []imageReference {
+	return []imageReference{
+		{productCollection, "image.filename"},
+		{categoriCollection, "image.filename"},
+		{userCollection, "image.filename"},
+		{productRevisionCollection, "snapshot.image.filename"},
+	}
+}
+
+// storedImage returns the object name of the image of the document with the given id
+func storedImage(ctx context.Context, collection *mongo.Collection, id string) (string, error) {
+	var doc struct {
+		Image *multipart.FileHeader `bson:"image"`
+	}
+	if err := collection.FindOne(ctx, bson.M{"_id": id}).Decode(&doc); err != nil {
+		return "", err
+	}
+	if doc.Image == nil {
+		return "", nil
+	}
+
+	return doc.Image.Filename, nil
+}
+
+func imageIsReferenced(ctx context.Context, objectName string) (bool, error) {
+	for _, reference := range imageReferences() {
+		count, err := reference.collection.CountDocuments(ctx, bson.M{reference.field: objectName})
+		if err != nil {
+			return false, err
+		}
+		if count > 0 {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// removeImageIfOrphaned deletes the object once no document references it anymore.
+// Failures are only logged, the reconciler picks up whatever is left behind.
+func removeImageIfOrphaned(ctx context.Context, objectName string) {
+	if objectName == "" {
+		return
+	}
+
+	referenced, err := imageIsReferenced(ctx, objectName)
+	if err != nil {
+		log.Printf("checking references of %s: %v", objectName, err)
+		return
+	}
+	if referenced {
+		return
+	}
+
+	if err := helpers.Blobs.Remove(ctx, objectName); err != nil {
+		log.Printf("removing orphaned image %s: %v", objectName, err)
+	}
+}
+
+func referencedImages(ctx context.Context) (map[string]bool, error) {
+	referenced := map[string]bool{}
+	for _, reference := range imageReferences() {
+		names, err := reference.collection.Distinct(ctx, reference.field, bson.M{})
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range names {
+			if s, ok := name.(string); ok {
+				referenced[s] = true
+			}
+		}
+	}
+
+	return referenced, nil
+}
+
+// reconcileBlobs compares the bucket with the image references of every
+// collection and removes the unreferenced objects unless dryRun is set
+func reconcileBlobs(ctx context.Context, dryRun bool, minAge time.Duration) (*reconcileReport, error) {
+	blobs, err := helpers.Blobs.List(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+
+	referenced, err := referencedImages(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &reconcileReport{
+		DryRun:  dryRun,
+		Scanned: len(blobs),
+		Orphans: []helpers.BlobInfo{},
+		Removed: []string{},
+		Failed:  []string{},
+	}
+	cutoff := time.Now().Add(-minAge)
+
+	for _, blob := range blobs {
+		if referenced[blob.Key] || blob.LastModified.After(cutoff) {
+			continue
+		}
+		report.Orphans = append(report.Orphans, blob)
+
+		if dryRun {
+			continue
+		}
+		if err := helpers.Blobs.Remove(ctx, blob.Key); err != nil {
+			log.Printf("removing orphaned image %s: %v", blob.Key, err)
+			report.Failed = append(report.Failed, blob.Key)
+			continue
+		}
+		report.Removed = append(report.Removed, blob.Key)
+	}
+
+	return report, nil
+}
+
+// ReconcileBlobs reports the orphaned objects of the bucket, and removes them
+// when called with dry_run=false
+func ReconcileBlobs(c *gin.Context) {
+	dryRun := c.DefaultQuery("dry_run", "true") != "false"
+
+	minAge := defaultOrphanMinAge
+	if value := c.Query("min_age"); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed < 0 {
+			responses.Error(c, responses.InvalidRequest, "Invalid min_age")
+			return
+		}
+		minAge = parsed
+	}
+
+	report, err := reconcileBlobs(c.Request.Context(), dryRun, minAge)
+	if err != nil {
+		responses.Error(c, responses.InternalError, "Error reconciling images")
+		return
+	}
+
+	responses.Success(c, http.StatusOK, fmt.Sprintf("Images reconciled. %d orphans found", len(report.Orphans)), report)
+}
+
+// StartBlobReconciler removes orphaned objects every interval until ctx is done
+func StartBlobReconciler(ctx context.Context, interval time.Duration, dryRun bool) {
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				report, err := reconcileBlobs(ctx, dryRun, defaultOrphanMinAge)
+				if err != nil {
+					log.Printf("reconciling images: %v", err)
+					continue
+				}
+				log.Printf("reconciled images: %d scanned, %d orphans, %d removed",
+					report.Scanned, len(report.Orphans), len(report.Removed))
+			}
+		}
+	}()
+}
@@ -0,0 +1,299 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"gin-api/configs"
+	"gin-api/models"
+	"gin-api/responses"
+	"gin-api/validation"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	maxBulkProducts = 1000
+	// products read and written per chunk
+	bulkChunkSize = 100
+)
+
+// statuses of a product in a bulk report
+const (
+	bulkUpdated    = "updated"
+	bulkDeleted    = "deleted"
+	bulkInvalid    = "invalid"     // the result would fail the validation of a single update
+	bulkNotFound   = "not_found"   // no such product outside of the trash
+	bulkConflict   = "conflict"    // the product changed while the operation ran
+	bulkRolledBack = "rolled_back" // applied, then undone by a failed atomic operation
+)
+
+// errBulkFailed aborts the transaction of an atomic operation
+var errBulkFailed = errors.New("bulk operation failed")
+
+type bulkResult struct {
+	ID      string                  `json:"id"`
+	Status  string                  `json:"status"`
+	Version int64                   `json:"version,omitempty"`
+	Errors  []validation.FieldError `json:"errors,omitempty"`
+}
+
+type bulkReport struct {
+	Operation string       `json:"operation"`
+	Mode      string       `json:"mode"`
+	Total     int          `json:"total"`
+	Succeeded int          `json:"succeeded"`
+	Failed    int          `json:"failed"`
+	Results   []bulkResult `json:"results"`
+}
+
+// productBulk runs a bulk request on behalf of the logged in user
+type productBulk struct {
+	request models.ProductBulkRequest
+	actor   models.AuditEntry
+	trash   bson.M // the update of a delete, every product shares its deleted_at
+	lang    string
+}
+
+// bulkTargets returns the ids of the request in order without duplicates, or
+// the ids matched by its filter. ok is false when the filter matches too many.
+func bulkTargets(ctx context.Context, request models.ProductBulkRequest) (ids []string, ok bool, err error) {
+	if len(request.IDs) > 0 {
+		seen := map[string]bool{}
+		for _, id := range request.IDs {
+			if !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+		return ids, true, nil
+	}
+
+	opts := options.Find().SetSort(bson.M{"_id": 1}).SetProjection(bson.M{"_id": 1}).SetLimit(maxBulkProducts + 1)
+	cur, err := productCollection.Find(ctx, productFilter(*request.Filter), opts)
+	if err != nil {
+		return nil, false, err
+	}
+	defer cur.Close(ctx)
+
+	var products []models.Product
+	if err := cur.All(ctx, &products); err != nil {
+		return nil, false, err
+	}
+	if len(products) > maxBulkProducts {
+		return nil, false, nil
+	}
+	for _, product := range products {
+		ids = append(ids, product.ID)
+	}
+	return ids, true, nil
+}
+
+// run applies the operation to every id, chunk by chunk, and returns a result per id
+func (b *productBulk) run(ctx context.Context, ids []string) ([]bulkResult, error) {
+	results := make([]bulkResult, 0, len(ids))
+	for start := 0; start < len(ids); start += bulkChunkSize {
+		chunk := ids[start:min(start+bulkChunkSize, len(ids))]
+
+		cur, err := productCollection.Find(ctx, bson.M{"_id": bson.M{"$in": chunk}, "deleted_at": bson.M{"$exists": false}})
+		if err != nil {
+			return nil, err
+		}
+		var products []models.Product
+		if err := cur.All(ctx, &products); err != nil {
+			return nil, err
+		}
+		found := map[string]models.Product{}
+		for _, product := range products {
+			found[product.ID] = product
+		}
+
+		for _, id := range chunk {
+			product, ok := found[id]
+			if !ok {
+				results = append(results, bulkResult{ID: id, Status: bulkNotFound})
+				continue
+			}
+			result, err := b.apply(ctx, product)
+			if err != nil {
+				return nil, err
+			}
+			results = append(results, result)
+		}
+	}
+	return results, nil
+}
+
+// apply writes the operation to one product, like the single item endpoint
+// would: validated, conditional on the version read, audited and revised
+func (b *productBulk) apply(ctx context.Context, product models.Product) (bulkResult, error) {
+	result := bulkResult{ID: product.ID}
+
+	var update bson.M
+	var patch models.ProductPatch
+	switch b.request.Operation {
+	case models.BulkAdjustPrice:
+		price, err := strconv.ParseFloat(product.Price, 64)
+		if err != nil {
+			result.Status = bulkInvalid
+			result.Errors = []validation.FieldError{validation.Field(b.lang, "price", "amount")}
+			return result, nil
+		}
+		adjusted := strconv.FormatFloat(math.Round(price*(100+b.request.Percent))/100, 'f', -1, 64)
+		patch.Price = &adjusted
+	case models.BulkSetStock:
+		patch.Stock = &b.request.Stock
+	case models.BulkSetCategori:
+		patch.Categori_id = &b.request.Categori_id
+	case models.BulkDelete:
+		update = b.trash
+	}
+
+	if update == nil {
+		if err := binding.Validator.ValidateStruct(&patch); err != nil {
+			result.Status = bulkInvalid
+			result.Errors, _ = validation.FieldErrors(err, b.lang)
+			return result, nil
+		}
+		set := toDocument(patch)
+		for field, value := range set {
+			if value == nil {
+				delete(set, field)
+			}
+		}
+		set["updatedat"] = time.Now()
+		update = bson.M{"$set": set, "$inc": bson.M{"version": 1}}
+	}
+
+	versions := []interface{}{product.Version}
+	if product.Version == 0 {
+		versions = append(versions, nil)
+	}
+	filter := bson.M{"_id": product.ID, "deleted_at": bson.M{"$exists": false}, "version": bson.M{"$in": versions}}
+	before, after, err := findAndUpdate(ctx, productCollection, filter, update, nil)
+	if err == mongo.ErrNoDocuments {
+		result.Status = bulkConflict
+		return result, nil
+	} else if err != nil {
+		return result, err
+	}
+	result.Version, _ = after["version"].(int64)
+
+	entry := b.actor
+	entry.Entity_type = entityProduct
+	entry.Entity_id = product.ID
+	if b.request.Operation == models.BulkDelete {
+		entry.Action = models.AuditDelete
+		result.Status = bulkDeleted
+	} else {
+		entry.Action = models.AuditUpdate
+		result.Status = bulkUpdated
+		recordProductRevision(ctx, b.actor.Actor_id, after, 0)
+	}
+	insertAudit(ctx, entry, before, after)
+	return result, nil
+}
+
+func newBulkReport(request models.ProductBulkRequest, results []bulkResult) bulkReport {
+	report := bulkReport{Operation: request.Operation, Mode: request.Mode, Total: len(results), Results: results}
+	for _, result := range results {
+		if result.Status == bulkUpdated || result.Status == bulkDeleted {
+			report.Succeeded++
+		} else {
+			report.Failed++
+		}
+	}
+	return report
+}
+
+// BulkProducts adjusts prices, sets the stock or categori, or deletes many
+// products at once, see models.ProductBulkRequest. The report has a result
+// per product. An atomic operation changes nothing unless every product succeeds.
+func BulkProducts(c *gin.Context) {
+	var request models.ProductBulkRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		responses.Validation(c, err)
+		return
+	}
+
+	lang := c.GetHeader("Accept-Language")
+	if len(request.IDs) > 0 && request.Filter != nil {
+		responses.ValidationFields(c, []responses.FieldError{{
+			Field:   "filter",
+			Rule:    "excluded_with",
+			Message: validation.Message(lang, "excluded_with", "filter", "ids"),
+		}})
+		return
+	}
+	if request.Mode == "" {
+		request.Mode = models.BulkChunked
+	}
+	if request.Operation == models.BulkSetCategori && !categoriAvailable(c, request.Categori_id) {
+		return
+	}
+
+	ctx := c.Request.Context()
+	ids, ok, err := bulkTargets(ctx, request)
+	if err != nil {
+		responses.Error(c, responses.InternalError, "Error fetching products")
+		return
+	}
+	if !ok {
+		responses.Error(c, responses.InvalidRequest, "The filter matches more than 1000 products")
+		return
+	}
+
+	bulk := &productBulk{request: request, actor: auditActor(c), trash: trashUpdate(c), lang: lang}
+	if request.Mode == models.BulkChunked {
+		results, err := bulk.run(ctx, ids)
+		if err != nil {
+			responses.Error(c, responses.InternalError, "Error running bulk operation")
+			return
+		}
+		responses.Success(c, http.StatusOK, "Bulk operation done", newBulkReport(request, results))
+		return
+	}
+
+	session, err := configs.DB.StartSession()
+	if err != nil {
+		responses.Error(c, responses.InternalError, "Error starting session")
+		return
+	}
+	defer session.EndSession(ctx)
+
+	var results []bulkResult
+	_, err = session.WithTransaction(ctx, func(sessCtx mongo.SessionContext) (interface{}, error) {
+		// the transaction may be retried, so the results start over
+		var err error
+		if results, err = bulk.run(sessCtx, ids); err != nil {
+			return nil, err
+		}
+		for _, result := range results {
+			if result.Status != bulkUpdated && result.Status != bulkDeleted {
+				return nil, errBulkFailed
+			}
+		}
+		return nil, nil
+	})
+	if errors.Is(err, errBulkFailed) {
+		for i, result := range results {
+			if result.Status == bulkUpdated || result.Status == bulkDeleted {
+				results[i] = bulkResult{ID: result.ID, Status: bulkRolledBack}
+			}
+		}
+		responses.ErrorWithData(c, responses.Conflict, "No product was changed, some products failed", newBulkReport(request, results))
+		return
+	} else if err != nil {
+		responses.Error(c, responses.InternalError, "Error running bulk operation")
+		return
+	}
+
+	responses.Success(c, http.StatusOK, "Bulk operation done", newBulkReport(request, results))
+}
@@ -2,11 +2,12 @@ package controllers
 
 import (
 	"context"
-	"fmt"
 	"gin-api/configs"
 	"gin-api/helpers"
 	"gin-api/models"
+	"gin-api/responses"
 	"net/http"
+	"regexp"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -32,42 +33,44 @@ func CreateCategori(c *gin.Context) {
 
 	// Use ShouldBind instead of ShouldBindJSON for form data
 	if err := c.ShouldBind(&categori); err != nil {
-		// Use StatusJSON to set both HTTP status and JSON response
-		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid form data: %s", err.Error())})
+		responses.Validation(c, err)
 		return
 	}
 
 	// Assign other fields and generate ID
 	categori.ID = uuid.New().String()
+	categori.Version = 1
 	categori.CreatedAt = time.Now()
 	categori.UpdatedAt = time.Now()
 	// Upload gambar ke MinIO
-	err := helpers.UploadImageToMinio(categori.Image, categori.Image.Filename)
+	objectName, err := helpers.UploadContentAddressedImage(categori.Image, helpers.CatalogPrefix)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error uploading image to MinIO"})
+		responses.Error(c, responses.InternalError, "Error uploading image to MinIO")
 		return
 	}
+	categori.Image.Filename = objectName
 	// Insert the categori into the database
 	_, err = categoriCollection.InsertOne(context.Background(), categori)
 	if err != nil {
 		// Use StatusJSON for consistent response format
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error creating product"})
+		responses.Error(c, responses.InternalError, "Error creating product")
 		return
 	}
+	recordAudit(c, models.AuditCreate, entityCategori, categori.ID, nil, categori)
 
 	result := gin.H{
 		"id":         categori.ID,
 		"name":       categori.Name,
+		"slug":       categori.Slug,
 		"image":      categori.Image.Filename,
+		"version":    categori.Version,
 		"created_at": categori.CreatedAt,
 		"update_at":  categori.UpdatedAt,
 	}
 
 	// Use StatusJSON for consistent response format
-	c.JSON(http.StatusCreated, gin.H{
-		"message": "Categories Created",
-		"data":    result,
-	})
+	setETag(c, categori.Version)
+	responses.Success(c, http.StatusCreated, "Categories Created", result)
 }
 
 // ref: https://swaggo.github.io/swaggo.io/declarative_comments_format/api_operation.html
@@ -81,35 +84,33 @@ func CreateCategori(c *gin.Context) {
 // @Failure 400 {object} model.HTTPError
 // @Router /accounts/{id} [get]
 func AllCategories(c *gin.Context) {
+	var query models.CategoriQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		responses.Validation(c, err)
+		return
+	}
+
 	var categories []models.Categori
 
-	cur, err := categoriCollection.Find(context.Background(), bson.D{})
+	cur, err := categoriCollection.Find(context.Background(), categoriFilter(query))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching categories"})
+		responses.Error(c, responses.InternalError, "Error fetching categories")
 		return
 	}
 	defer cur.Close(context.Background())
 
 	if err := cur.All(context.Background(), &categories); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error decoding categories"})
+		responses.Error(c, responses.InternalError, "Error decoding categories")
 		return
 	}
 
 	var result []gin.H
 
 	for _, v := range categories {
-		data := gin.H{
-			"id":    v.ID,
-			"name":  v.Name,
-			"image": v.Image.Filename,
-		}
-		result = append(result, data)
+		result = append(result, categoriResult(v))
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"message": "Get All Categories",
-		"data":    result,
-	})
+	responses.Success(c, http.StatusOK, "Get All Categories", result)
 }
 
 // ref: https://swaggo.github.io/swaggo.io/declarative_comments_format/api_operation.html
@@ -123,31 +124,26 @@ func AllCategories(c *gin.Context) {
 // @Failure 400 {object} model.HTTPError
 // @Router /accounts/{id} [get]
 func OneCategori(c *gin.Context) {
-	categoriID := c.Param("id")
+	categoriID, ok := bindID(c)
+	if !ok {
+		return
+	}
 
-	filter := bson.M{"_id": categoriID}
+	filter := bson.M{"_id": categoriID, "deleted_at": bson.M{"$exists": false}}
 	var categories models.Categori
 	err := categoriCollection.FindOne(context.Background(), filter).Decode(&categories)
 
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
-			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			responses.Error(c, responses.CategoryNotFound, "Categori not found")
 			return
 		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching categori"})
+		responses.Error(c, responses.InternalError, "Error fetching categori")
 		return
 	}
 
-	result := gin.H{
-		"id":    categories.ID,
-		"name":  categories.Name,
-		"image": categories.Image.Filename,
-	}
-
-	c.JSON(http.StatusOK, gin.H{
-		"message": "Get One Categories",
-		"data":    result,
-	})
+	setETag(c, categories.Version)
+	responses.Success(c, http.StatusOK, "Get One Categories", categoriResult(categories))
 }
 
 // ref: https://swaggo.github.io/swaggo.io/declarative_comments_format/api_operation.html
@@ -163,49 +159,152 @@ func OneCategori(c *gin.Context) {
 func UpdateCategori(c *gin.Context) {
 	var categori models.UpdateCategoriRequest
 
-	// Parse UUID first
-	categoriID := c.Param("id")
-	uuid, err := uuid.Parse(categoriID)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
+	categoriID, ok := bindID(c)
+	if !ok {
 		return
 	}
 
-	if err := c.ShouldBind(&categori); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	filter := bson.M{"_id": categoriID, "deleted_at": bson.M{"$exists": false}}
+	if !ifMatch(c, filter) {
 		return
 	}
 
-	// Set the ID after binding
-	categori.ID = uuid.String()
+	if err := c.ShouldBind(&categori); err != nil {
+		responses.Validation(c, err)
+		return
+	}
 
-	filter := bson.M{"_id": categori.ID}
-	update := bson.M{"$set": categori}
+	categori.ID = categoriID
+	categori.UpdatedAt = time.Now()
 
+	var oldImage string
+	var err error
 	if categori.Image != nil {
-		err := helpers.UploadImageToMinio(categori.Image, categori.Image.Filename)
+		oldImage, err = storedImage(context.TODO(), categoriCollection, categori.ID)
+		if err != nil && err != mongo.ErrNoDocuments {
+			responses.Error(c, responses.InternalError, "Error fetching categori")
+			return
+		}
+
+		objectName, err := helpers.UploadContentAddressedImage(categori.Image, helpers.CatalogPrefix)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error uploading image to MinIO"})
+			responses.Error(c, responses.InternalError, "Error uploading image to MinIO")
 			return
 		}
+		categori.Image.Filename = objectName
 	}
 
-	_, err = categoriCollection.UpdateOne(context.TODO(), filter, update)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error updating"})
+	updateCategoriFields(c, filter, bson.M{"$set": categori}, oldImage)
+}
+
+// ref: https://swaggo.github.io/swaggo.io/declarative_comments_format/api_operation.html
+// @Summary Update categori fields
+// @Description changes only the fields sent as JSON Merge Patch or form fields, null or empty removes an optional field
+// @Tags categories
+// @Accept  json,mpfd
+// @Produce  json
+// @Param id path string true "Categori ID"
+// @Success 200 {object} responses.Result
+// @Failure 400 {object} responses.Result
+// @Router /categori/updateCategori/{id} [patch]
+func PatchCategori(c *gin.Context) {
+	categoriID, ok := bindID(c)
+	if !ok {
 		return
 	}
-	fmt.Print(categori)
-	result := gin.H{
-		"id":    categori.ID,
-		"name":  categori.Name,
-		"image": categori.Image.Filename,
+
+	filter := bson.M{"_id": categoriID, "deleted_at": bson.M{"$exists": false}}
+	if !ifMatch(c, filter) {
+		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"message": "Categori updated",
-		"data":    result,
-	})
+	var patch models.CategoriPatch
+	set, unset, ok := bindMergePatch(c, &patch, "slug")
+	if !ok {
+		return
+	}
+
+	oldImage, err := storedImage(context.TODO(), categoriCollection, categoriID)
+	if err == mongo.ErrNoDocuments {
+		responses.Error(c, responses.CategoryNotFound, "Categori not found")
+		return
+	} else if err != nil {
+		responses.Error(c, responses.InternalError, "Error fetching categori")
+		return
+	}
+
+	// a multipart patch may replace the image
+	if image, err := c.FormFile("image"); err == nil {
+		objectName, err := helpers.UploadContentAddressedImage(image, helpers.CatalogPrefix)
+		if err != nil {
+			responses.Error(c, responses.InternalError, "Error uploading image to MinIO")
+			return
+		}
+		image.Filename = objectName
+		set["image"] = image
+	}
+
+	set["updatedat"] = time.Now()
+	update := bson.M{"$set": set}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+	updateCategoriFields(c, filter, update, oldImage)
+}
+
+// updateCategoriFields applies update to the categori matching filter, bumps
+// its version and responds with the stored categori. oldImage is removed when
+// the update replaced it.
+func updateCategoriFields(c *gin.Context, filter, update bson.M, oldImage string) {
+	update["$inc"] = bson.M{"version": 1}
+
+	var categori models.Categori
+	before, after, err := findAndUpdate(context.TODO(), categoriCollection, filter, update, &categori)
+	if err == mongo.ErrNoDocuments {
+		missedWrite(c, categoriCollection, filter, responses.CategoryNotFound, "Categori not found")
+		return
+	} else if err != nil {
+		responses.Error(c, responses.InternalError, "Error updating")
+		return
+	}
+	recordAudit(c, models.AuditUpdate, entityCategori, categori.ID, before, after)
+
+	// Remove the replaced image
+	if categori.Image != nil && oldImage != categori.Image.Filename {
+		removeImageIfOrphaned(context.TODO(), oldImage)
+	}
+
+	setETag(c, categori.Version)
+	responses.Success(c, http.StatusOK, "Categori updated", categoriResult(categori))
+}
+
+// categoriFilter selects the categories outside of the trash matching the query
+func categoriFilter(query models.CategoriQuery) bson.M {
+	filter := bson.M{"deleted_at": bson.M{"$exists": false}}
+	if query.Q != "" {
+		pattern := bson.M{"$regex": regexp.QuoteMeta(query.Q), "$options": "i"}
+		filter["$or"] = bson.A{bson.M{"name": pattern}, bson.M{"slug": pattern}}
+	}
+	if !query.Updated_since.IsZero() {
+		filter["updatedat"] = bson.M{"$gte": query.Updated_since}
+	}
+	return filter
+}
+
+// categoriResult is the categori returned by the read and update endpoints
+func categoriResult(categori models.Categori) gin.H {
+	var image string
+	if categori.Image != nil {
+		image = categori.Image.Filename
+	}
+
+	return gin.H{
+		"id":      categori.ID,
+		"name":    categori.Name,
+		"slug":    categori.Slug,
+		"image":   image,
+		"version": categori.Version,
+	}
 }
 
 // ref: https://swaggo.github.io/swaggo.io/declarative_comments_format/api_operation.html
@@ -219,19 +318,26 @@ func UpdateCategori(c *gin.Context) {
 // @Failure 400 {object} model.HTTPError
 // @Router /accounts/{id} [get]
 func DeleteCategori(c *gin.Context) {
-	categoriID := c.Param("id")
+	categoriID, ok := bindID(c)
+	if !ok {
+		return
+	}
+
+	filter := bson.M{"_id": categoriID, "deleted_at": bson.M{"$exists": false}}
+	if !ifMatch(c, filter) {
+		return
+	}
 
-	filter := bson.M{"_id": categoriID}
-	result, err := categoriCollection.DeleteOne(context.Background(), filter)
+	// the categori moves to the trash, it is purged with its image after the retention period
+	before, after, err := findAndUpdate(context.Background(), categoriCollection, filter, trashUpdate(c), nil)
 	if err == mongo.ErrNoDocuments {
-		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		missedWrite(c, categoriCollection, filter, responses.CategoryNotFound, "Categori not found")
 		return
 	} else if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error deleting categori"})
+		responses.Error(c, responses.InternalError, "Error deleting categori")
 		return
 	}
+	recordAudit(c, models.AuditDelete, entityCategori, categoriID, before, after)
 
-	c.JSON(http.StatusOK, gin.H{
-		"message": fmt.Sprintf("User deleted. %d documents deleted", result.DeletedCount),
-	})
+	responses.Success(c, http.StatusOK, "Categori deleted", nil)
 }
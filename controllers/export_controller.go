@@ -0,0 +1,270 @@
+package controllers
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"gin-api/helpers"
+	"gin-api/models"
+	"gin-api/responses"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// exportWriter writes the records of an export one at a time
+type exportWriter interface {
+	Write(record []interface{}) error
+	Close() error
+}
+
+var exportContentTypes = map[string]string{
+	"csv":   "text/csv",
+	"jsonl": "application/x-ndjson",
+	"xlsx":  helpers.XLSXContentType,
+}
+
+// newExportWriter starts a file of the format, CSV and XLSX begin with a header row
+func newExportWriter(format string, w io.Writer, header []string, sheet string) (exportWriter, error) {
+	switch format {
+	case "csv":
+		writer := &csvExport{writer: csv.NewWriter(w)}
+		return writer, writer.writer.Write(header)
+	case "xlsx":
+		writer, err := helpers.NewXLSXWriter(w, sheet)
+		if err != nil {
+			return nil, err
+		}
+		row := make([]interface{}, len(header))
+		for i, name := range header {
+			row[i] = name
+		}
+		return &xlsxExport{writer}, writer.WriteRow(row)
+	default:
+		return &jsonLinesExport{encoder: json.NewEncoder(w), header: header}, nil
+	}
+}
+
+type csvExport struct {
+	writer *csv.Writer
+}
+
+func (e *csvExport) Write(record []interface{}) error {
+	row := make([]string, len(record))
+	for i, value := range record {
+		switch value := value.(type) {
+		case nil:
+		case time.Time:
+			row[i] = value.Format(time.RFC3339)
+		case string:
+			row[i] = escapeFormula(value)
+		default:
+			row[i] = fmt.Sprint(value)
+		}
+	}
+	return e.writer.Write(row)
+}
+
+// escapeFormula prefixes text a spreadsheet would run as a formula with a quote
+func escapeFormula(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
+
+func (e *csvExport) Close() error {
+	e.writer.Flush()
+	return e.writer.Error()
+}
+
+// jsonLinesExport writes a JSON object per record, keyed by the header
+type jsonLinesExport struct {
+	encoder *json.Encoder
+	header  []string
+}
+
+func (e *jsonLinesExport) Write(record []interface{}) error {
+	object := make(map[string]interface{}, len(e.header))
+	for i, name := range e.header {
+		object[name] = record[i]
+	}
+	return e.encoder.Encode(object)
+}
+
+func (e *jsonLinesExport) Close() error {
+	return nil
+}
+
+type xlsxExport struct {
+	writer *helpers.XLSXWriter
+}
+
+func (e *xlsxExport) Write(record []interface{}) error {
+	return e.writer.WriteRow(record)
+}
+
+func (e *xlsxExport) Close() error {
+	return e.writer.Close()
+}
+
+// imageFields returns the object name and public URL of an image, nil without one
+func imageFields(image *multipart.FileHeader) (interface{}, interface{}) {
+	if image == nil || image.Filename == "" {
+		return nil, nil
+	}
+	return image.Filename, helpers.AssetURL(image.Filename)
+}
+
+// streamExport writes every document of the cursor as a record of the file,
+// decoding one document at a time. record decodes the current document.
+func streamExport(c *gin.Context, cur *mongo.Cursor, format, name string, header []string, record func(*mongo.Cursor) ([]interface{}, error)) {
+	ctx := c.Request.Context()
+	defer cur.Close(ctx)
+
+	c.Header("Content-Type", exportContentTypes[format])
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.%s"`, name, format))
+	c.Status(http.StatusOK)
+
+	// the status is sent, a failure from here on can only end the file early
+	writer, err := newExportWriter(format, c.Writer, header, name)
+	if err != nil {
+		log.Printf("exporting %s: %v", name, err)
+		return
+	}
+	for cur.Next(ctx) {
+		values, err := record(cur)
+		if err != nil {
+			log.Printf("exporting %s: %v", name, err)
+			return
+		}
+		if err := writer.Write(values); err != nil {
+			log.Printf("exporting %s: %v", name, err)
+			return
+		}
+	}
+	if err := cur.Err(); err != nil {
+		log.Printf("exporting %s: %v", name, err)
+		return
+	}
+	if err := writer.Close(); err != nil {
+		log.Printf("exporting %s: %v", name, err)
+	}
+}
+
+var productExportHeader = []string{
+	"id", "sku", "name", "slug", "price", "currency", "desc", "stock", "weight", "categori_id", "categori_slug", "categori_path",
+	"image", "image_url", "version", "created_at", "updated_at",
+}
+
+// categoriPath is the readable location of a categori, categories are flat
+// so it is the name alone
+func categoriPath(categori models.Categori) string {
+	return categori.Name
+}
+
+// exportCategories returns every categori by id, trashed ones included since
+// products may still reference them
+func exportCategories(ctx context.Context) (map[string]models.Categori, error) {
+	cur, err := categoriCollection.Find(ctx, bson.M{}, options.Find().SetProjection(bson.M{"name": 1, "slug": 1}))
+	if err != nil {
+		return nil, err
+	}
+	var categories []models.Categori
+	if err := cur.All(ctx, &categories); err != nil {
+		return nil, err
+	}
+
+	byID := make(map[string]models.Categori, len(categories))
+	for _, categori := range categories {
+		byID[categori.ID] = categori
+	}
+	return byID, nil
+}
+
+// ExportProducts streams the products matching the filters of AllProduct as CSV, JSON lines or XLSX
+func ExportProducts(c *gin.Context) {
+	var query models.ProductQuery
+	var export models.ExportQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		responses.Validation(c, err)
+		return
+	}
+	if err := c.ShouldBindQuery(&export); err != nil {
+		responses.Validation(c, err)
+		return
+	}
+
+	categories, err := exportCategories(c.Request.Context())
+	if err != nil {
+		responses.Error(c, responses.InternalError, "Error fetching categories")
+		return
+	}
+
+	opts := options.Find().SetSort(bson.M{"_id": 1})
+	cur, err := productCollection.Find(c.Request.Context(), productFilter(query), opts)
+	if err != nil {
+		responses.Error(c, responses.InternalError, "Error fetching products")
+		return
+	}
+
+	streamExport(c, cur, export.Format, "products", productExportHeader, func(cur *mongo.Cursor) ([]interface{}, error) {
+		var product models.Product
+		if err := cur.Decode(&product); err != nil {
+			return nil, err
+		}
+		image, imageURL := imageFields(product.Image)
+		var categoriSlug, location interface{}
+		if categori, ok := categories[product.Categori_id]; ok {
+			categoriSlug, location = categori.Slug, categoriPath(categori)
+		}
+		return []interface{}{
+			product.ID, product.Sku, product.Name, product.Slug, product.Price, product.Currency, product.Desc,
+			product.Stock, product.Weight, product.Categori_id, categoriSlug, location,
+			image, imageURL, product.Version, product.Created_at, product.Updated_at,
+		}, nil
+	})
+}
+
+var categoriExportHeader = []string{"id", "name", "slug", "path", "image", "image_url", "version", "created_at", "updated_at"}
+
+// ExportCategories streams the categories matching the filters of AllCategories as CSV, JSON lines or XLSX
+func ExportCategories(c *gin.Context) {
+	var query models.CategoriQuery
+	var export models.ExportQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		responses.Validation(c, err)
+		return
+	}
+	if err := c.ShouldBindQuery(&export); err != nil {
+		responses.Validation(c, err)
+		return
+	}
+
+	opts := options.Find().SetSort(bson.M{"_id": 1})
+	cur, err := categoriCollection.Find(c.Request.Context(), categoriFilter(query), opts)
+	if err != nil {
+		responses.Error(c, responses.InternalError, "Error fetching categories")
+		return
+	}
+
+	streamExport(c, cur, export.Format, "categories", categoriExportHeader, func(cur *mongo.Cursor) ([]interface{}, error) {
+		var categori models.Categori
+		if err := cur.Decode(&categori); err != nil {
+			return nil, err
+		}
+		image, imageURL := imageFields(categori.Image)
+		return []interface{}{
+			categori.ID, categori.Name, categori.Slug, categoriPath(categori), image, imageURL, categori.Version, categori.CreatedAt, categori.UpdatedAt,
+		}, nil
+	})
+}
@@ -0,0 +1,39 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/csv"
+	"testing"
+)
+
+func TestCSVExportEscapesFormulas(t *testing.T) {
+	var buf bytes.Buffer
+	writer, err := newExportWriter("csv", &buf, []string{"name", "desc", "stock"}, "products")
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer.Write([]interface{}{"=HYPERLINK(\"http://x\")", "+1", 3})
+	writer.Write([]interface{}{"@SUM(A1)", "-2", -4})
+	writer.Write([]interface{}{"plain", "\tcmd", nil})
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{
+		{"name", "desc", "stock"},
+		{"'=HYPERLINK(\"http://x\")", "'+1", "3"},
+		{"'@SUM(A1)", "'-2", "-4"},
+		{"plain", "'\tcmd", ""},
+	}
+	for i := range want {
+		for j := range want[i] {
+			if records[i][j] != want[i][j] {
+				t.Fatalf("row %d column %d: got %q, want %q", i, j, records[i][j], want[i][j])
+			}
+		}
+	}
+}
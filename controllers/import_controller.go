@@ -0,0 +1,687 @@
+package controllers
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gin-api/configs"
+	"gin-api/helpers"
+	"gin-api/models"
+	"gin-api/responses"
+	"gin-api/validation"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var productImportCollection *mongo.Collection = configs.GetCollection(configs.DB, "product_imports")
+var productImportErrorCollection *mongo.Collection = configs.GetCollection(configs.DB, "product_import_errors")
+
+// errImportDeleted is returned when the sku of a row belongs to a trashed product
+var errImportDeleted = errors.New("sku belongs to a deleted product")
+
+const (
+	maxImportFileSize   = 50 << 20
+	maxImportBundleSize = 500 << 20
+	// progress is stored every importFlushRows rows
+	importFlushRows = 100
+)
+
+// columns of a CSV import and keys of a JSON lines import
+var importColumns = []string{"sku", "name", "slug", "image", "price", "currency", "desc", "stock", "weight"}
+
+// importRow is a line of an import file
+type importRow struct {
+	line   int
+	fields map[string]string
+	errors []validation.FieldError // the line could not be read
+}
+
+func importProductRow(fields map[string]string) models.ProductImportRow {
+	var row models.ProductImportRow
+	for name, value := range map[string]*string{
+		"sku": &row.Sku, "name": &row.Name, "slug": &row.Slug, "image": &row.Image, "price": &row.Price,
+		"currency": &row.Currency, "desc": &row.Desc, "stock": &row.Stock, "weight": &row.Weight,
+	} {
+		*value = strings.TrimSpace(fields[name])
+	}
+	return row
+}
+
+func isImportColumn(name string) bool {
+	for _, column := range importColumns {
+		if column == name {
+			return true
+		}
+	}
+	return false
+}
+
+// parseImportFile reads the rows of a CSV or JSON lines file. fields lists the
+// problems refusing the whole file, err is set when it cannot be read at all.
+func parseImportFile(format string, data []byte, lang string) (rows []importRow, fields []validation.FieldError, err error) {
+	if format == "csv" {
+		return parseImportCSV(data, lang)
+	}
+	return parseImportJSONLines(data, lang)
+}
+
+func parseImportCSV(data []byte, lang string) ([]importRow, []validation.FieldError, error) {
+	reader := csv.NewReader(bytes.NewReader(data))
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, []validation.FieldError{validation.Field(lang, "file", "required")}, nil
+	} else if err != nil {
+		return nil, nil, err
+	}
+
+	var fields []validation.FieldError
+	hasSku := false
+	for i, name := range header {
+		// spreadsheets often start the file with a byte order mark
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		header[i] = name
+		if !isImportColumn(name) {
+			fields = append(fields, validation.Field(lang, name, "unknown"))
+		}
+		hasSku = hasSku || name == "sku"
+	}
+	if !hasSku {
+		fields = append(fields, validation.Field(lang, "sku", "required"))
+	}
+	if len(fields) > 0 {
+		return nil, fields, nil
+	}
+
+	rows := []importRow{}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		var parseError *csv.ParseError
+		if errors.As(err, &parseError) && parseError.Err == csv.ErrFieldCount {
+			// a short or long line fails alone, the next lines are still read
+			rows = append(rows, importRow{line: parseError.StartLine, errors: []validation.FieldError{validation.Field(lang, "row", "invalid")}})
+			continue
+		} else if err != nil {
+			return nil, nil, err
+		}
+
+		line, _ := reader.FieldPos(0)
+		row := importRow{line: line, fields: map[string]string{}}
+		for i, value := range record {
+			row.fields[header[i]] = value
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil, nil
+}
+
+func parseImportJSONLines(data []byte, lang string) ([]importRow, []validation.FieldError, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+
+	rows := []importRow{}
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+
+		row := importRow{line: line, fields: map[string]string{}}
+		var values map[string]interface{}
+		decoder := json.NewDecoder(strings.NewReader(text))
+		decoder.UseNumber()
+		if err := decoder.Decode(&values); err != nil {
+			row.errors = append(row.errors, validation.Field(lang, "row", "invalid"))
+			rows = append(rows, row)
+			continue
+		}
+
+		for key, value := range values {
+			if !isImportColumn(key) {
+				row.errors = append(row.errors, validation.Field(lang, key, "unknown"))
+				continue
+			}
+			switch value := value.(type) {
+			case string:
+				row.fields[key] = value
+			case json.Number:
+				row.fields[key] = value.String()
+			case nil:
+			default:
+				row.errors = append(row.errors, validation.FieldError{Field: key, Rule: "type", Message: validation.Message(lang, "type", key, "string")})
+			}
+		}
+		rows = append(rows, row)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+	if len(rows) == 0 {
+		return nil, []validation.FieldError{validation.Field(lang, "file", "required")}, nil
+	}
+	return rows, nil, nil
+}
+
+func readFormFile(file *multipart.FileHeader) ([]byte, error) {
+	reader, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	return io.ReadAll(reader)
+}
+
+// saveImportBundle copies the images zip to a temporary file, multipart
+// files are removed once the request ends but the job needs the images longer
+func saveImportBundle(file *multipart.FileHeader) (string, error) {
+	reader, err := file.Open()
+	if err != nil {
+		return "", err
+	}
+	defer reader.Close()
+
+	bundle, err := os.CreateTemp("", "product-import-*.zip")
+	if err != nil {
+		return "", err
+	}
+	_, err = io.Copy(bundle, reader)
+	if closeErr := bundle.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		var archive *zip.ReadCloser
+		if archive, err = zip.OpenReader(bundle.Name()); err == nil {
+			archive.Close()
+		}
+	}
+	if err != nil {
+		os.Remove(bundle.Name())
+		return "", err
+	}
+	return bundle.Name(), nil
+}
+
+// ImportProducts starts a background job upserting the products of a CSV or
+// JSON lines file by sku, see models.ProductImportRow for the columns
+func ImportProducts(c *gin.Context) {
+	var request models.ProductImportRequest
+	if err := c.ShouldBind(&request); err != nil {
+		responses.Validation(c, err)
+		return
+	}
+
+	lang := c.GetHeader("Accept-Language")
+	file, err := c.FormFile("file")
+	if err != nil {
+		responses.ValidationFields(c, []responses.FieldError{validation.Field(lang, "file", "required")})
+		return
+	}
+	if file.Size > maxImportFileSize {
+		responses.Error(c, responses.FileTooLarge, "Import file is too large")
+		return
+	}
+
+	format := request.Format
+	if format == "" {
+		switch strings.ToLower(filepath.Ext(file.Filename)) {
+		case ".csv":
+			format = "csv"
+		case ".jsonl", ".ndjson":
+			format = "jsonl"
+		default:
+			responses.ValidationFields(c, []responses.FieldError{validation.Field(lang, "format", "required")})
+			return
+		}
+	}
+
+	data, err := readFormFile(file)
+	if err != nil {
+		responses.Error(c, responses.InternalError, "Error reading import file")
+		return
+	}
+	rows, fields, err := parseImportFile(format, data, lang)
+	if err != nil {
+		responses.Error(c, responses.InvalidRequest, fmt.Sprintf("Malformed import file: %v", err))
+		return
+	}
+	if len(fields) > 0 {
+		responses.ValidationFields(c, fields)
+		return
+	}
+
+	var bundle string
+	if images, err := c.FormFile("images"); err == nil {
+		if images.Size > maxImportBundleSize {
+			responses.Error(c, responses.FileTooLarge, "Image bundle is too large")
+			return
+		}
+		if bundle, err = saveImportBundle(images); err != nil {
+			responses.ValidationFields(c, []responses.FieldError{validation.Field(lang, "images", "invalid")})
+			return
+		}
+	}
+
+	job := models.ProductImport{
+		ID:         uuid.New().String(),
+		Status:     models.ImportPending,
+		Format:     format,
+		Dry_run:    request.DryRun,
+		Total:      len(rows),
+		Created_by: currentUserID(c),
+		Created_at: time.Now(),
+	}
+	if _, err := productImportCollection.InsertOne(c.Request.Context(), job); err != nil {
+		if bundle != "" {
+			os.Remove(bundle)
+		}
+		responses.Error(c, responses.InternalError, "Error creating import")
+		return
+	}
+
+	importer := &productImporter{job: job, actor: auditActor(c), lang: lang, images: map[string]*zip.File{}}
+	go importer.run(context.Background(), rows, bundle)
+
+	c.Header("Location", "/api/product/imports/"+job.ID)
+	responses.Success(c, http.StatusAccepted, "Import started", job)
+}
+
+// productImporter runs an import job, rows are handled one after the other
+// so a sku repeated in the file updates the product its first row created
+type productImporter struct {
+	job    models.ProductImport
+	actor  models.AuditEntry // the user who started the import
+	lang   string
+	images map[string]*zip.File
+	errors []interface{} // row errors not stored yet
+}
+
+func (p *productImporter) run(ctx context.Context, rows []importRow, bundle string) {
+	// a panic fails the job instead of the server
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("import %s panicked: %v\n%s", p.job.ID, r, debug.Stack())
+			p.finish(ctx, fmt.Errorf("import stopped at row %d", p.job.Processed+1))
+		}
+	}()
+
+	if bundle != "" {
+		defer os.Remove(bundle)
+		archive, err := zip.OpenReader(bundle)
+		if err != nil {
+			p.finish(ctx, err)
+			return
+		}
+		defer archive.Close()
+		for _, file := range archive.File {
+			if !file.FileInfo().IsDir() {
+				p.images[path.Clean(file.Name)] = file
+			}
+		}
+	}
+
+	p.job.Status = models.ImportRunning
+	p.flush(ctx)
+	for _, row := range rows {
+		p.importRow(ctx, row)
+		p.job.Processed++
+		if p.job.Processed%importFlushRows == 0 {
+			p.flush(ctx)
+		}
+	}
+	p.finish(ctx, nil)
+}
+
+// FailInterruptedImports marks the jobs a restart stopped as failed, the
+// importer runs in the server process so nothing resumes them
+func FailInterruptedImports(ctx context.Context) error {
+	filter := bson.M{"status": bson.M{"$in": []string{models.ImportPending, models.ImportRunning}}}
+	update := bson.M{"$set": bson.M{
+		"status":      models.ImportFailed,
+		"error":       "interrupted by a server restart",
+		"finished_at": time.Now(),
+	}}
+	_, err := productImportCollection.UpdateMany(ctx, filter, update)
+	return err
+}
+
+func (p *productImporter) finish(ctx context.Context, err error) {
+	now := time.Now()
+	p.job.Finished_at = &now
+	p.job.Status = models.ImportCompleted
+	if err != nil {
+		p.job.Status = models.ImportFailed
+		p.job.Error = err.Error()
+	}
+	p.flush(ctx)
+}
+
+// flush stores the pending row errors and the progress of the job
+func (p *productImporter) flush(ctx context.Context) {
+	if len(p.errors) > 0 {
+		if _, err := productImportErrorCollection.InsertMany(ctx, p.errors); err != nil {
+			log.Printf("storing errors of import %s: %v", p.job.ID, err)
+		}
+		p.errors = nil
+	}
+	if _, err := productImportCollection.ReplaceOne(ctx, bson.M{"_id": p.job.ID}, p.job); err != nil {
+		log.Printf("storing progress of import %s: %v", p.job.ID, err)
+	}
+}
+
+func (p *productImporter) reject(row importRow, sku string, fields ...validation.FieldError) {
+	p.job.Failed++
+	for _, field := range fields {
+		p.errors = append(p.errors, models.ProductImportError{
+			ID:        uuid.New().String(),
+			Import_id: p.job.ID,
+			Row:       row.line,
+			Sku:       sku,
+			Field:     field.Field,
+			Rule:      field.Rule,
+			Message:   field.Message,
+		})
+	}
+}
+
+// failure is the row error of a database or storage failure
+func (p *productImporter) failure(row importRow, sku string, err error) {
+	log.Printf("importing row %d of import %s: %v", row.line, p.job.ID, err)
+	p.reject(row, sku, validation.FieldError{Field: "row", Rule: "internal", Message: "The row could not be stored"})
+}
+
+func (p *productImporter) importRow(ctx context.Context, row importRow) {
+	product := importProductRow(row.fields)
+	if len(row.errors) > 0 {
+		p.reject(row, product.Sku, row.errors...)
+		return
+	}
+	if err := binding.Validator.ValidateStruct(&product); err != nil {
+		fields, _ := validation.FieldErrors(err, p.lang)
+		p.reject(row, product.Sku, fields...)
+		return
+	}
+
+	var existing models.Product
+	err := productCollection.FindOne(ctx, bson.M{"sku": product.Sku}).Decode(&existing)
+	found := err == nil
+	if err != nil && err != mongo.ErrNoDocuments {
+		p.failure(row, product.Sku, err)
+		return
+	}
+	if found && existing.Deleted_at != nil {
+		p.reject(row, product.Sku, validation.Field(p.lang, "sku", "deleted"))
+		return
+	}
+	if !found && product.Image == "" {
+		p.reject(row, product.Sku, validation.Field(p.lang, "image", "required"))
+		return
+	}
+
+	image, field, err := p.resolveImage(ctx, product.Image)
+	if err != nil {
+		p.failure(row, product.Sku, err)
+		return
+	}
+	if field != nil {
+		p.reject(row, product.Sku, *field)
+		return
+	}
+
+	if p.job.Dry_run {
+		if found {
+			p.job.Updated++
+		} else {
+			p.job.Created++
+		}
+		return
+	}
+
+	if found {
+		err = p.updateProduct(ctx, existing, product, image)
+	} else {
+		err = p.createProduct(ctx, product, image)
+	}
+	if mongo.IsDuplicateKeyError(err) {
+		// the sku was created since it was looked up, the row updates that product
+		err = productCollection.FindOne(ctx, bson.M{"sku": product.Sku}).Decode(&existing)
+		if err == nil && existing.Deleted_at != nil {
+			err = errImportDeleted
+		} else if err == nil {
+			err = p.updateProduct(ctx, existing, product, image)
+		}
+	}
+	if err != nil && image != nil {
+		removeImageIfOrphaned(ctx, image.Filename)
+	}
+	switch {
+	case err == nil:
+	case errors.Is(err, errImportDeleted):
+		p.reject(row, product.Sku, validation.Field(p.lang, "sku", "deleted"))
+	case errors.Is(err, mongo.ErrNoDocuments):
+		// the product was edited or trashed after it was read
+		p.reject(row, product.Sku, validation.Field(p.lang, "sku", "changed"))
+	default:
+		p.failure(row, product.Sku, err)
+	}
+}
+
+// resolveImage returns the image named by a row, a file of the images zip is
+// uploaded unless the job is a dry run. field is set when the image is refused.
+func (p *productImporter) resolveImage(ctx context.Context, name string) (*multipart.FileHeader, *validation.FieldError, error) {
+	if name == "" {
+		return nil, nil, nil
+	}
+	refused := func(rule string) (*multipart.FileHeader, *validation.FieldError, error) {
+		field := validation.Field(p.lang, "image", rule)
+		return nil, &field, nil
+	}
+
+	if file, ok := p.images[path.Clean(name)]; ok {
+		if file.UncompressedSize64 > uint64(helpers.MaxUploadSize()) {
+			return refused("invalid")
+		}
+		reader, err := file.Open()
+		if err != nil {
+			return refused("invalid")
+		}
+		data, err := io.ReadAll(reader)
+		reader.Close()
+		if err != nil {
+			return refused("invalid")
+		}
+
+		objectName, contentType, err := helpers.ContentAddressedData(data)
+		if err != nil {
+			return refused("invalid")
+		}
+		objectName = helpers.CatalogPrefix + objectName
+		if !p.job.Dry_run {
+			if err := helpers.Blobs.Put(ctx, objectName, bytes.NewReader(data), int64(len(data)), contentType); err != nil {
+				return nil, nil, err
+			}
+		}
+		return &multipart.FileHeader{
+			Filename: objectName,
+			Size:     int64(len(data)),
+			Header:   textproto.MIMEHeader{"Content-Type": {contentType}},
+		}, nil, nil
+	}
+
+	// otherwise the key of an image already stored, e.g. by a presigned upload
+	if !strings.HasPrefix(name, helpers.CatalogPrefix) {
+		return refused("not_found")
+	}
+	info, err := helpers.Blobs.Stat(ctx, name)
+	if errors.Is(err, helpers.ErrBlobNotFound) {
+		return refused("not_found")
+	} else if err != nil {
+		return nil, nil, err
+	}
+	return &multipart.FileHeader{
+		Filename: name,
+		Size:     info.Size,
+		Header:   textproto.MIMEHeader{"Content-Type": {info.ContentType}},
+	}, nil, nil
+}
+
+func (p *productImporter) createProduct(ctx context.Context, row models.ProductImportRow, image *multipart.FileHeader) error {
+	now := time.Now()
+	product := models.CreateProductRequest{
+		ID:        uuid.New().String(),
+		Name:      row.Name,
+		Sku:       row.Sku,
+		Slug:      row.Slug,
+		Image:     image,
+		Price:     row.Price,
+		Currency:  row.Currency,
+		Desc:      row.Desc,
+		Stock:     row.Stock,
+		Weight:    row.Weight,
+		Version:   1,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if _, err := productCollection.InsertOne(ctx, product); err != nil {
+		return err
+	}
+
+	entry := p.actor
+	entry.Action = models.AuditCreate
+	entry.Entity_type = entityProduct
+	entry.Entity_id = product.ID
+	insertAudit(ctx, entry, nil, product)
+	recordProductRevision(ctx, p.actor.Actor_id, toDocument(product), 0)
+	p.job.Created++
+	return nil
+}
+
+// updateProduct overwrites the fields of the row, empty optional fields keep their stored value.
+// It returns mongo.ErrNoDocuments when the product changed since existing was read.
+func (p *productImporter) updateProduct(ctx context.Context, existing models.Product, row models.ProductImportRow, image *multipart.FileHeader) error {
+	set := bson.M{"name": row.Name, "price": row.Price, "updatedat": time.Now()}
+	for field, value := range map[string]string{"slug": row.Slug, "currency": row.Currency, "desc": row.Desc, "stock": row.Stock, "weight": row.Weight} {
+		if value != "" {
+			set[field] = value
+		}
+	}
+	if image != nil {
+		set["image"] = image
+	}
+
+	// products stored before versioning have no version field
+	versions := []interface{}{existing.Version}
+	if existing.Version == 0 {
+		versions = append(versions, nil)
+	}
+	filter := bson.M{"_id": existing.ID, "deleted_at": bson.M{"$exists": false}, "version": bson.M{"$in": versions}}
+	update := bson.M{"$set": set, "$inc": bson.M{"version": 1}}
+	before, after, err := findAndUpdate(ctx, productCollection, filter, update, nil)
+	if err != nil {
+		return err
+	}
+
+	entry := p.actor
+	entry.Action = models.AuditUpdate
+	entry.Entity_type = entityProduct
+	entry.Entity_id = existing.ID
+	insertAudit(ctx, entry, before, after)
+	recordProductRevision(ctx, p.actor.Actor_id, after, 0)
+	if image != nil && existing.Image != nil && existing.Image.Filename != image.Filename {
+		removeImageIfOrphaned(ctx, existing.Image.Filename)
+	}
+	p.job.Updated++
+	return nil
+}
+
+func findProductImport(c *gin.Context) (models.ProductImport, bool) {
+	var job models.ProductImport
+	importID, ok := bindID(c)
+	if !ok {
+		return job, false
+	}
+
+	err := productImportCollection.FindOne(c.Request.Context(), bson.M{"_id": importID}).Decode(&job)
+	if err == mongo.ErrNoDocuments {
+		responses.Error(c, responses.ImportNotFound, "Import not found")
+		return job, false
+	} else if err != nil {
+		responses.Error(c, responses.InternalError, "Error fetching import")
+		return job, false
+	}
+	return job, true
+}
+
+// GetProductImport returns the progress of an import
+func GetProductImport(c *gin.Context) {
+	job, ok := findProductImport(c)
+	if !ok {
+		return
+	}
+
+	responses.Success(c, http.StatusOK, "Get Product Import", job)
+}
+
+// ProductImportReport downloads the refused rows of an import as CSV, ordered by line
+func ProductImportReport(c *gin.Context) {
+	job, ok := findProductImport(c)
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	opts := options.Find().SetSort(bson.D{{Key: "row", Value: 1}, {Key: "_id", Value: 1}})
+	cur, err := productImportErrorCollection.Find(ctx, bson.M{"import_id": job.ID}, opts)
+	if err != nil {
+		responses.Error(c, responses.InternalError, "Error fetching import errors")
+		return
+	}
+	defer cur.Close(ctx)
+
+	c.Header("Content-Type", "text/csv")
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="product-import-%s.csv"`, job.ID))
+	c.Status(http.StatusOK)
+
+	writer := csv.NewWriter(c.Writer)
+	writer.Write([]string{"row", "sku", "field", "rule", "message"})
+	for cur.Next(ctx) {
+		var rowError models.ProductImportError
+		if err := cur.Decode(&rowError); err != nil {
+			log.Printf("exporting errors of import %s: %v", job.ID, err)
+			break
+		}
+		// sku and message echo the uploaded file
+		writer.Write([]string{
+			fmt.Sprint(rowError.Row), escapeFormula(rowError.Sku), escapeFormula(rowError.Field), rowError.Rule, escapeFormula(rowError.Message),
+		})
+	}
+	if err := cur.Err(); err != nil {
+		log.Printf("exporting errors of import %s: %v", job.ID, err)
+	}
+	writer.Flush()
+}
@@ -0,0 +1,36 @@
+package controllers
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// collectionIndexes lists the indexes the handlers rely on
+func collectionIndexes() map[*mongo.Collection][]mongo.IndexModel {
+	return map[*mongo.Collection][]mongo.IndexModel{
+		// imports upsert by sku, products without one are left out
+		productCollection: {
+			{
+				Keys:    bson.M{"sku": 1},
+				Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{"sku": bson.M{"$gt": ""}}),
+			},
+		},
+		// expired authorization requests are removed by MongoDB
+		oidcStateCollection: {
+			{Keys: bson.M{"expires_at": 1}, Options: options.Index().SetExpireAfterSeconds(0)},
+		},
+	}
+}
+
+// EnsureIndexes creates the missing indexes, existing ones are left as they are
+func EnsureIndexes(ctx context.Context) error {
+	for collection, indexes := range collectionIndexes() {
+		if _, err := collection.Indexes().CreateMany(ctx, indexes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
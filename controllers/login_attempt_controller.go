@@ -0,0 +1,202 @@
+package controllers
+
+import (
+	"context"
+	"gin-api/configs"
+	"gin-api/models"
+	"gin-api/responses"
+	"log"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var loginAttemptCollection *mongo.Collection = configs.GetCollection(configs.DB, "login_attempts")
+var loginEventCollection *mongo.Collection = configs.GetCollection(configs.DB, "login_events")
+
+const (
+	// failures are forgotten after a quiet window
+	loginFailureWindow = 15 * time.Minute
+	// every failure after loginDelayAfter doubles the wait before the next try
+	loginDelayAfter = 3
+	maxLoginDelay   = 30 * time.Second
+	// accounts and IPs are locked after this many failures in the window
+	accountLockAfter  = 10
+	ipLockAfter       = 50
+	loginLockDuration = 15 * time.Minute
+)
+
+func accountAttemptKey(username string) string {
+	return "user:" + strings.ToLower(username)
+}
+
+func ipAttemptKey(ip string) string {
+	return "ip:" + ip
+}
+
+// loginBlockedUntil returns until when logins are refused for any of the keys
+func loginBlockedUntil(ctx context.Context, keys ...string) (time.Time, error) {
+	cur, err := loginAttemptCollection.Find(ctx, bson.M{"_id": bson.M{"$in": keys}})
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer cur.Close(ctx)
+
+	var attempts []models.LoginAttempt
+	if err := cur.All(ctx, &attempts); err != nil {
+		return time.Time{}, err
+	}
+
+	var until time.Time
+	for _, attempt := range attempts {
+		if attempt.Locked_until.After(until) {
+			until = attempt.Locked_until
+		}
+		if attempt.Next_allowed_at.After(until) {
+			until = attempt.Next_allowed_at
+		}
+	}
+	return until, nil
+}
+
+func loginDelay(failures int) time.Duration {
+	if failures < loginDelayAfter {
+		return 0
+	}
+
+	delay := time.Duration(math.Pow(2, float64(failures-loginDelayAfter))) * time.Second
+	if delay > maxLoginDelay {
+		return maxLoginDelay
+	}
+	return delay
+}
+
+// recordLoginFailure counts a failure for the key and applies the delay and lockout
+func recordLoginFailure(ctx context.Context, key string, lockAfter int) error {
+	now := time.Now()
+
+	// start counting again after a quiet window
+	_, err := loginAttemptCollection.UpdateOne(ctx,
+		bson.M{"_id": key, "last_failure_at": bson.M{"$lt": now.Add(-loginFailureWindow)}},
+		bson.M{"$set": bson.M{"failures": 0}},
+	)
+	if err != nil {
+		return err
+	}
+
+	var attempt models.LoginAttempt
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	err = loginAttemptCollection.FindOneAndUpdate(ctx,
+		bson.M{"_id": key},
+		bson.M{"$inc": bson.M{"failures": 1}, "$set": bson.M{"last_failure_at": now}},
+		opts,
+	).Decode(&attempt)
+	if err != nil {
+		return err
+	}
+
+	update := bson.M{"next_allowed_at": now.Add(loginDelay(attempt.Failures))}
+	if attempt.Failures >= lockAfter {
+		update["locked_until"] = now.Add(loginLockDuration)
+	}
+	_, err = loginAttemptCollection.UpdateOne(ctx, bson.M{"_id": key}, bson.M{"$set": update})
+	return err
+}
+
+func clearLoginFailures(ctx context.Context, key string) error {
+	_, err := loginAttemptCollection.DeleteOne(ctx, bson.M{"_id": key})
+	return err
+}
+
+// recordLoginEvent stores a failed or blocked login, errors are only logged
+func recordLoginEvent(c *gin.Context, username, userID, reason string) {
+	event := models.LoginEvent{
+		ID:         uuid.New().String(),
+		Username:   username,
+		User_id:    userID,
+		IP:         c.ClientIP(),
+		User_agent: c.Request.UserAgent(),
+		Reason:     reason,
+		Created_at: time.Now(),
+	}
+	if _, err := loginEventCollection.InsertOne(context.Background(), event); err != nil {
+		log.Printf("recording login event: %v", err)
+	}
+}
+
+// loginFailed counts the failure for the account and the IP and records the event
+func loginFailed(c *gin.Context, username, userID, reason string) {
+	ctx := context.Background()
+	if err := recordLoginFailure(ctx, accountAttemptKey(username), accountLockAfter); err != nil {
+		log.Printf("recording login failure: %v", err)
+	}
+	if err := recordLoginFailure(ctx, ipAttemptKey(c.ClientIP()), ipLockAfter); err != nil {
+		log.Printf("recording login failure: %v", err)
+	}
+	recordLoginEvent(c, username, userID, reason)
+}
+
+// UnlockUser clears the failed logins and lockout of a user
+func UnlockUser(c *gin.Context) {
+	userID, ok := bindID(c)
+	if !ok {
+		return
+	}
+
+	var user models.User
+	err := userCollection.FindOne(context.Background(), bson.M{"_id": userID}).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		responses.Error(c, responses.UserNotFound, "User not found")
+		return
+	} else if err != nil {
+		responses.Error(c, responses.InternalError, "Error fetching user")
+		return
+	}
+
+	if err := clearLoginFailures(context.Background(), accountAttemptKey(user.Username)); err != nil {
+		responses.Error(c, responses.InternalError, "Error unlocking user")
+		return
+	}
+
+	responses.Success(c, http.StatusOK, "User unlocked", nil)
+}
+
+// LoginEvents lists the latest failed logins, filtered by username or ip
+func LoginEvents(c *gin.Context) {
+	query := models.LoginEventsQuery{Limit: 100}
+	if err := c.ShouldBindQuery(&query); err != nil {
+		responses.Validation(c, err)
+		return
+	}
+
+	filter := bson.M{}
+	if query.Username != "" {
+		filter["username"] = query.Username
+	}
+	if query.IP != "" {
+		filter["ip"] = query.IP
+	}
+
+	opts := options.Find().SetSort(bson.M{"created_at": -1}).SetLimit(query.Limit)
+	cur, err := loginEventCollection.Find(context.Background(), filter, opts)
+	if err != nil {
+		responses.Error(c, responses.InternalError, "Error fetching login events")
+		return
+	}
+	defer cur.Close(context.Background())
+
+	events := []models.LoginEvent{}
+	if err := cur.All(context.Background(), &events); err != nil {
+		responses.Error(c, responses.InternalError, "Error decoding login events")
+		return
+	}
+
+	responses.Success(c, http.StatusOK, "Get Login Events", events)
+}
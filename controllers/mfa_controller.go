@@ -0,0 +1,381 @@
+package controllers
+
+import (
+	"context"
+	"gin-api/helpers"
+	"gin-api/models"
+	"gin-api/responses"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	mfaChallengeExpiration = 5 * time.Minute
+	mfaEnrollExpiration    = 15 * time.Minute
+	recoveryCodeCount      = 10
+)
+
+func mfaIssuer() string {
+	if issuer := os.Getenv("MFA_ISSUER"); issuer != "" {
+		return issuer
+	}
+	return "gin-api"
+}
+
+// scopedToken signs a short lived token that only grants one step of the two-factor login
+func scopedToken(user models.User, scope string, expiration time.Duration) (string, error) {
+	claims := helpers.SignedDetails{
+		UserID:       user.ID,
+		Scope:        scope,
+		TokenVersion: user.Token_version,
+	}
+	claims.ExpiresAt = jwt.NewNumericDate(time.Now().Add(expiration))
+
+	return helpers.GenerateAllTokens(&claims)
+}
+
+// newRecoveryCodes returns fresh codes to show once and their hashes to store
+func newRecoveryCodes() ([]string, []string, error) {
+	codes, err := helpers.GenerateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	hashes := make([]string, len(codes))
+	for i, code := range codes {
+		hashes[i] = helpers.HashOpaqueToken(code)
+	}
+	return codes, hashes, nil
+}
+
+// useTotpCode checks the code and remembers its time step so it cannot be replayed
+func useTotpCode(ctx context.Context, user models.User, secret, code string) (bool, error) {
+	step, ok := helpers.ValidateTOTP(secret, code, time.Now())
+	if !ok || step <= user.Mfa_last_step {
+		return false, nil
+	}
+
+	filter := bson.M{"_id": user.ID, "mfa_last_step": bson.M{"$not": bson.M{"$gte": step}}}
+	result, err := userCollection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"mfa_last_step": step}})
+	if err != nil {
+		return false, err
+	}
+	return result.ModifiedCount == 1, nil
+}
+
+// useRecoveryCode removes the matching recovery code, so it works once
+func useRecoveryCode(ctx context.Context, user models.User, code string) (bool, error) {
+	hash := helpers.HashOpaqueToken(helpers.NormalizeRecoveryCode(code))
+
+	filter := bson.M{"_id": user.ID, "mfa_recovery_codes": hash}
+	result, err := userCollection.UpdateOne(ctx, filter, bson.M{"$pull": bson.M{"mfa_recovery_codes": hash}})
+	if err != nil {
+		return false, err
+	}
+	return result.ModifiedCount == 1, nil
+}
+
+func findCurrentUser(c *gin.Context) (models.User, bool) {
+	var user models.User
+	err := userCollection.FindOne(c.Request.Context(), bson.M{"_id": currentUserID(c)}).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		responses.Error(c, responses.UserNotFound, "User not found")
+		return user, false
+	} else if err != nil {
+		responses.Error(c, responses.InternalError, "Error fetching user")
+		return user, false
+	}
+	return user, true
+}
+
+// EnrollMfa starts two-factor enrollment and returns the secret and provisioning URI
+func EnrollMfa(c *gin.Context) {
+	user, ok := findCurrentUser(c)
+	if !ok {
+		return
+	}
+	if user.Mfa_enabled {
+		responses.Error(c, responses.MfaAlreadyEnabled, "Two-factor authentication is already enabled")
+		return
+	}
+
+	secret, err := helpers.GenerateTOTPSecret()
+	if err != nil {
+		responses.Error(c, responses.InternalError, "Error enrolling two-factor authentication")
+		return
+	}
+
+	update := bson.M{"$set": bson.M{"mfa_pending_secret": secret, "updated_at": time.Now()}}
+	before, after, err := findAndUpdate(c.Request.Context(), userCollection, bson.M{"_id": user.ID}, update, nil)
+	if err != nil {
+		responses.Error(c, responses.InternalError, "Error enrolling two-factor authentication")
+		return
+	}
+	recordAudit(c, models.AuditUpdate, entityUser, user.ID, before, after)
+
+	responses.Success(c, http.StatusOK, "Scan the provisioning URI and confirm with the first code", gin.H{
+		"secret":           secret,
+		"provisioning_uri": helpers.TOTPProvisioningURI(mfaIssuer(), user.Username, secret),
+	})
+}
+
+// ConfirmMfa enables two-factor authentication with the first code and returns the recovery codes
+func ConfirmMfa(c *gin.Context) {
+	var request models.MfaCodeRequest
+	if err := c.ShouldBind(&request); err != nil {
+		responses.Validation(c, err)
+		return
+	}
+
+	user, ok := findCurrentUser(c)
+	if !ok {
+		return
+	}
+	if user.Mfa_pending_secret == "" {
+		responses.Error(c, responses.InvalidRequest, "Two-factor enrollment was not started")
+		return
+	}
+
+	step, valid := helpers.ValidateTOTP(user.Mfa_pending_secret, request.Code, time.Now())
+	if !valid {
+		responses.Error(c, responses.InvalidMfaCode, "Invalid code")
+		return
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		responses.Error(c, responses.InternalError, "Error enabling two-factor authentication")
+		return
+	}
+
+	update := bson.M{
+		"$set": bson.M{
+			"mfa_enabled":        true,
+			"mfa_secret":         user.Mfa_pending_secret,
+			"mfa_recovery_codes": hashes,
+			"mfa_last_step":      step,
+			"updated_at":         time.Now(),
+		},
+		"$unset": bson.M{"mfa_pending_secret": ""},
+	}
+	before, after, err := findAndUpdate(c.Request.Context(), userCollection, bson.M{"_id": user.ID}, update, nil)
+	if err != nil {
+		responses.Error(c, responses.InternalError, "Error enabling two-factor authentication")
+		return
+	}
+	recordAudit(c, models.AuditUpdate, entityUser, user.ID, before, after)
+
+	responses.Success(c, http.StatusOK, "Two-factor authentication enabled, store the recovery codes safely", gin.H{
+		"recovery_codes": codes,
+	})
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes after checking a current code
+func RegenerateRecoveryCodes(c *gin.Context) {
+	var request models.MfaCodeRequest
+	if err := c.ShouldBind(&request); err != nil {
+		responses.Validation(c, err)
+		return
+	}
+
+	user, ok := findCurrentUser(c)
+	if !ok {
+		return
+	}
+	if !user.Mfa_enabled {
+		responses.Error(c, responses.MfaNotEnabled, "Two-factor authentication is not enabled")
+		return
+	}
+
+	valid, err := useTotpCode(c.Request.Context(), user, user.Mfa_secret, request.Code)
+	if err != nil {
+		responses.Error(c, responses.InternalError, "Error verifying code")
+		return
+	}
+	if !valid {
+		responses.Error(c, responses.InvalidMfaCode, "Invalid code")
+		return
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		responses.Error(c, responses.InternalError, "Error creating recovery codes")
+		return
+	}
+
+	update := bson.M{"$set": bson.M{"mfa_recovery_codes": hashes, "updated_at": time.Now()}}
+	before, after, err := findAndUpdate(c.Request.Context(), userCollection, bson.M{"_id": user.ID}, update, nil)
+	if err != nil {
+		responses.Error(c, responses.InternalError, "Error creating recovery codes")
+		return
+	}
+	recordAudit(c, models.AuditUpdate, entityUser, user.ID, before, after)
+
+	responses.Success(c, http.StatusOK, "Recovery codes replaced", gin.H{
+		"recovery_codes": codes,
+	})
+}
+
+// DisableMfa turns two-factor authentication off, unless the role of the user requires it
+func DisableMfa(c *gin.Context) {
+	var request models.MfaDisableRequest
+	if err := c.ShouldBind(&request); err != nil {
+		responses.Validation(c, err)
+		return
+	}
+
+	user, ok := findCurrentUser(c)
+	if !ok {
+		return
+	}
+	if !user.Mfa_enabled {
+		responses.Error(c, responses.MfaNotEnabled, "Two-factor authentication is not enabled")
+		return
+	}
+
+	var role models.Role
+	err := roleCollection.FindOne(c.Request.Context(), bson.M{"_id": user.Role_id}).Decode(&role)
+	if err == nil && role.Require_mfa {
+		responses.Error(c, responses.MfaRequired, "Two-factor authentication is required for your role")
+		return
+	}
+
+	if !VerifyPassword(request.Password, user.Password) {
+		responses.Error(c, responses.Unauthorized, "Current password is incorrect")
+		return
+	}
+	valid, err := useTotpCode(c.Request.Context(), user, user.Mfa_secret, request.Code)
+	if err != nil {
+		responses.Error(c, responses.InternalError, "Error verifying code")
+		return
+	}
+	if !valid {
+		responses.Error(c, responses.InvalidMfaCode, "Invalid code")
+		return
+	}
+
+	update := bson.M{
+		"$unset": bson.M{"mfa_enabled": "", "mfa_secret": "", "mfa_recovery_codes": "", "mfa_last_step": ""},
+		"$set":   bson.M{"updated_at": time.Now()},
+	}
+	before, after, err := findAndUpdate(c.Request.Context(), userCollection, bson.M{"_id": user.ID}, update, nil)
+	if err != nil {
+		responses.Error(c, responses.InternalError, "Error disabling two-factor authentication")
+		return
+	}
+	recordAudit(c, models.AuditUpdate, entityUser, user.ID, before, after)
+
+	responses.Success(c, http.StatusOK, "Two-factor authentication disabled", nil)
+}
+
+// VerifyMfa finishes the two-factor login and returns the real token
+func VerifyMfa(c *gin.Context) {
+	var request models.MfaVerifyRequest
+	if err := c.ShouldBind(&request); err != nil {
+		responses.Validation(c, err)
+		return
+	}
+
+	claims, err := helpers.DecodeToken(request.ChallengeToken)
+	if err != nil || claims.Scope != helpers.ScopeMfaChallenge {
+		responses.Error(c, responses.InvalidToken, "Invalid or expired challenge")
+		return
+	}
+	ctx := c.Request.Context()
+	var user models.User
+	err = userCollection.FindOne(ctx, bson.M{"_id": claims.UserID}).Decode(&user)
+	if err != nil || !user.Mfa_enabled || claims.TokenVersion != user.Token_version {
+		responses.Error(c, responses.InvalidToken, "Invalid or expired challenge")
+		return
+	}
+
+	// codes are throttled like passwords
+	accountKey := accountAttemptKey(user.Username)
+	blockedUntil, err := loginBlockedUntil(ctx, accountKey, ipAttemptKey(c.ClientIP()))
+	if err != nil {
+		responses.Error(c, responses.InternalError, "Error signing in")
+		return
+	}
+	if time.Until(blockedUntil) > 0 {
+		responses.Error(c, responses.AccountLocked, "Too many failed attempts, try again later")
+		return
+	}
+
+	var valid bool
+	if request.RecoveryCode != "" {
+		valid, err = useRecoveryCode(ctx, user, request.RecoveryCode)
+	} else {
+		valid, err = useTotpCode(ctx, user, user.Mfa_secret, request.Code)
+	}
+	if err != nil {
+		responses.Error(c, responses.InternalError, "Error verifying code")
+		return
+	}
+	if !valid {
+		loginFailed(c, user.Username, user.ID, "wrong_mfa_code")
+		responses.Error(c, responses.Unauthorized, "Invalid code")
+		return
+	}
+
+	if err := clearLoginFailures(ctx, accountKey); err != nil {
+		log.Println(err)
+	}
+
+	var role models.Role
+	err = roleCollection.FindOne(ctx, bson.M{"_id": user.Role_id}).Decode(&role)
+	if err != nil {
+		responses.Error(c, responses.InternalError, "Role not found")
+		return
+	}
+
+	token, err := loginToken(c, user, role.Name)
+	if err != nil {
+		log.Println(err)
+		responses.Error(c, responses.InternalError, "Error signing in")
+		return
+	}
+
+	c.SetCookie("jwt", token, 86400, "/", "localhost", false, true)
+
+	responses.Success(c, http.StatusOK, "Login Successfully!!!", gin.H{
+		"name":  user.Name,
+		"token": token,
+	})
+}
+
+// UpdateRoleMfaPolicy sets whether users of the role must use two-factor authentication
+func UpdateRoleMfaPolicy(c *gin.Context) {
+	roleID, ok := bindID(c)
+	if !ok {
+		return
+	}
+
+	var request models.RoleMfaPolicyRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		responses.Validation(c, err)
+		return
+	}
+
+	update := bson.M{"$set": bson.M{"require_mfa": *request.Require_mfa, "updated_at": time.Now()}}
+	before, after, err := findAndUpdate(c.Request.Context(), roleCollection, bson.M{"_id": roleID}, update, nil)
+	if err == mongo.ErrNoDocuments {
+		responses.Error(c, responses.RoleNotFound, "Role not found")
+		return
+	} else if err != nil {
+		responses.Error(c, responses.InternalError, "Error updating role")
+		return
+	}
+	recordAudit(c, models.AuditUpdate, entityRole, roleID, before, after)
+
+	responses.Success(c, http.StatusOK, "Role two-factor policy updated", gin.H{
+		"id":          roleID,
+		"require_mfa": *request.Require_mfa,
+	})
+}
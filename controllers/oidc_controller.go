@@ -0,0 +1,402 @@
+package controllers
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"gin-api/configs"
+	"gin-api/helpers"
+	"gin-api/models"
+	"gin-api/responses"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var identityCollection *mongo.Collection = configs.GetCollection(configs.DB, "user_identities")
+var oidcStateCollection *mongo.Collection = configs.GetCollection(configs.DB, "oidc_states")
+
+const oidcStateExpiration = 10 * time.Minute
+
+// the state is also kept in this cookie, so that a callback is only accepted
+// from the browser that started the flow
+const oidcStateCookie = "oidc_state"
+
+var errInvalidOidcState = errors.New("invalid or expired state")
+
+func identityID(provider, subject string) string {
+	return provider + "|" + subject
+}
+
+// oidcProvider writes a 404 for providers that are not configured
+func oidcProvider(c *gin.Context) (*helpers.OIDCProvider, bool) {
+	provider, err := helpers.GetOIDCProvider(c.Param("provider"))
+	if err != nil {
+		responses.Error(c, responses.ProviderNotFound, "Unknown identity provider")
+		return nil, false
+	}
+	return provider, true
+}
+
+// startOidcFlow stores the state of a new authorization request, binds it to
+// the browser with the state cookie and returns the provider URL
+func startOidcFlow(c *gin.Context, provider *helpers.OIDCProvider, linkUserID string) (string, error) {
+	ctx := c.Request.Context()
+	state, stateHash, err := helpers.NewOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+	nonce, _, err := helpers.NewOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+	verifier, challenge, err := helpers.NewPKCEVerifier()
+	if err != nil {
+		return "", err
+	}
+
+	authURL, err := provider.AuthCodeURL(ctx, state, nonce, challenge)
+	if err != nil {
+		return "", err
+	}
+
+	oidcState := models.OidcState{
+		ID:            stateHash,
+		Provider:      provider.Name,
+		Code_verifier: verifier,
+		Nonce:         nonce,
+		Link_user_id:  linkUserID,
+		Expires_at:    time.Now().Add(oidcStateExpiration),
+	}
+	if _, err := oidcStateCollection.InsertOne(ctx, oidcState); err != nil {
+		return "", err
+	}
+
+	setOidcStateCookie(c, state, int(oidcStateExpiration.Seconds()))
+	return authURL, nil
+}
+
+// setOidcStateCookie sends the state cookie to the callback only, the provider
+// redirect is a top level navigation so SameSite=Lax still sends it
+func setOidcStateCookie(c *gin.Context, state string, maxAge int) {
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcStateCookie, state, maxAge, "/api/auth/oidc", "", c.Request.TLS != nil, true)
+}
+
+// oidcStateFromBrowser tells whether the state of the callback is the one of the browser's cookie
+func oidcStateFromBrowser(c *gin.Context, state string) bool {
+	cookie, err := c.Cookie(oidcStateCookie)
+	if err != nil || cookie == "" || state == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(cookie), []byte(state)) == 1
+}
+
+// consumeOidcState deletes the state so that a callback can only be used once
+func consumeOidcState(ctx context.Context, provider, state string) (models.OidcState, error) {
+	filter := bson.M{
+		"_id":        helpers.HashOpaqueToken(state),
+		"provider":   provider,
+		"expires_at": bson.M{"$gt": time.Now()},
+	}
+
+	var oidcState models.OidcState
+	err := oidcStateCollection.FindOneAndDelete(ctx, filter).Decode(&oidcState)
+	if err == mongo.ErrNoDocuments {
+		return oidcState, errInvalidOidcState
+	}
+	return oidcState, err
+}
+
+// availableUsername returns the preferred username, with a suffix when it is taken
+func availableUsername(ctx context.Context, claims *helpers.OIDCClaims) (string, error) {
+	username := claims.PreferredUsername
+	if username == "" {
+		username = strings.SplitN(claims.Email, "@", 2)[0]
+	}
+	if username == "" {
+		username = "user"
+	}
+
+	candidate := username
+	for i := 0; i < 5; i++ {
+		count, err := userCollection.CountDocuments(ctx, bson.M{"username": candidate})
+		if err != nil {
+			return "", err
+		}
+		if count == 0 {
+			return candidate, nil
+		}
+		candidate = username + "-" + uuid.New().String()[:6]
+	}
+	return "", errors.New("no available username")
+}
+
+// createOidcUser registers the user of a first sign in. The user has no password
+// until one is set through the forgotten password flow.
+func createOidcUser(ctx context.Context, provider string, claims *helpers.OIDCClaims) (models.User, error) {
+	username, err := availableUsername(ctx, claims)
+	if err != nil {
+		return models.User{}, err
+	}
+	roleID, err := defaultRoleID(ctx)
+	if err != nil {
+		return models.User{}, err
+	}
+
+	now := time.Now()
+	user := models.User{
+		ID:          uuid.New().String(),
+		Username:    username,
+		Name:        claims.Name,
+		Email:       claims.Email,
+		Role_id:     roleID,
+		Verified_at: &now,
+		Version:     1,
+		Created_at:  now,
+		Updated_at:  now,
+	}
+	if _, err := userCollection.InsertOne(ctx, user); err != nil {
+		return models.User{}, err
+	}
+
+	identity := models.Identity{
+		ID:         identityID(provider, claims.Subject),
+		User_id:    user.ID,
+		Provider:   provider,
+		Subject:    claims.Subject,
+		Email:      claims.Email,
+		Created_at: now,
+	}
+	if _, err := identityCollection.InsertOne(ctx, identity); err != nil {
+		return models.User{}, err
+	}
+
+	return user, nil
+}
+
+// OidcLogin redirects to the login page of the provider
+func OidcLogin(c *gin.Context) {
+	provider, ok := oidcProvider(c)
+	if !ok {
+		return
+	}
+
+	authURL, err := startOidcFlow(c, provider, "")
+	if err != nil {
+		log.Printf("starting %s sign in: %v", provider.Name, err)
+		responses.Error(c, responses.UpstreamUnavailable, "Identity provider unavailable")
+		return
+	}
+
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// OidcCallback finishes the sign in, or the linking, started at the provider
+func OidcCallback(c *gin.Context) {
+	provider, ok := oidcProvider(c)
+	if !ok {
+		return
+	}
+	if providerError := c.Query("error"); providerError != "" {
+		responses.Error(c, responses.Unauthorized, "Sign in cancelled or refused by the provider: "+providerError)
+		return
+	}
+
+	// a state started in another browser, e.g. a link crafted by an attacker
+	if !oidcStateFromBrowser(c, c.Query("state")) {
+		responses.Error(c, responses.InvalidToken, "Invalid or expired sign in request")
+		return
+	}
+	setOidcStateCookie(c, "", -1)
+
+	ctx := c.Request.Context()
+	oidcState, err := consumeOidcState(ctx, provider.Name, c.Query("state"))
+	if err == errInvalidOidcState {
+		responses.Error(c, responses.InvalidToken, "Invalid or expired sign in request")
+		return
+	} else if err != nil {
+		responses.Error(c, responses.InternalError, "Error signing in")
+		return
+	}
+
+	idToken, err := provider.Exchange(ctx, c.Query("code"), oidcState.Code_verifier)
+	if err != nil {
+		log.Printf("exchanging %s code: %v", provider.Name, err)
+		responses.Error(c, responses.Unauthorized, "Sign in failed")
+		return
+	}
+	claims, err := provider.VerifyIDToken(ctx, idToken, oidcState.Nonce)
+	if err != nil {
+		log.Printf("verifying %s id token: %v", provider.Name, err)
+		responses.Error(c, responses.Unauthorized, "Sign in failed")
+		return
+	}
+
+	if oidcState.Link_user_id != "" {
+		linkIdentity(c, provider.Name, oidcState.Link_user_id, claims)
+		return
+	}
+
+	var identity models.Identity
+	err = identityCollection.FindOne(ctx, bson.M{"_id": identityID(provider.Name, claims.Subject)}).Decode(&identity)
+	if err != nil && err != mongo.ErrNoDocuments {
+		responses.Error(c, responses.InternalError, "Error signing in")
+		return
+	}
+
+	var user models.User
+	if err == nil {
+		if err := userCollection.FindOne(ctx, bson.M{"_id": identity.User_id}).Decode(&user); err != nil {
+			responses.Error(c, responses.InternalError, "Error signing in")
+			return
+		}
+	} else {
+		// accounts are never linked by email alone, the owner links them after signing in
+		if claims.Email == "" || !claims.EmailVerified {
+			responses.Error(c, responses.Forbidden, "The provider did not share a verified email")
+			return
+		}
+		taken, err := userCollection.CountDocuments(ctx, bson.M{"email": claims.Email})
+		if err != nil {
+			responses.Error(c, responses.InternalError, "Error signing in")
+			return
+		}
+		if taken > 0 {
+			responses.Error(c, responses.UserExists, "An account already uses this email, sign in and link the provider")
+			return
+		}
+
+		if user, err = createOidcUser(ctx, provider.Name, claims); err != nil {
+			log.Printf("creating %s user: %v", provider.Name, err)
+			responses.Error(c, responses.InternalError, "Error creating user")
+			return
+		}
+	}
+
+	completeLogin(ctx, c, user)
+}
+
+// linkIdentity adds the provider identity to the user who started the linking
+func linkIdentity(c *gin.Context, provider, userID string, claims *helpers.OIDCClaims) {
+	ctx := c.Request.Context()
+
+	var existing models.Identity
+	err := identityCollection.FindOne(ctx, bson.M{"_id": identityID(provider, claims.Subject)}).Decode(&existing)
+	if err == nil {
+		if existing.User_id != userID {
+			responses.Error(c, responses.IdentityLinked, "This account is already linked to another user")
+			return
+		}
+		responses.Success(c, http.StatusOK, "Provider already linked", existing)
+		return
+	} else if err != mongo.ErrNoDocuments {
+		responses.Error(c, responses.InternalError, "Error linking provider")
+		return
+	}
+
+	// one identity per provider and user
+	count, err := identityCollection.CountDocuments(ctx, bson.M{"user_id": userID, "provider": provider})
+	if err != nil {
+		responses.Error(c, responses.InternalError, "Error linking provider")
+		return
+	}
+	if count > 0 {
+		responses.Error(c, responses.IdentityLinked, "Another account of this provider is already linked")
+		return
+	}
+
+	identity := models.Identity{
+		ID:         identityID(provider, claims.Subject),
+		User_id:    userID,
+		Provider:   provider,
+		Subject:    claims.Subject,
+		Email:      claims.Email,
+		Created_at: time.Now(),
+	}
+	if _, err := identityCollection.InsertOne(ctx, identity); err != nil {
+		responses.Error(c, responses.InternalError, "Error linking provider")
+		return
+	}
+
+	responses.Success(c, http.StatusCreated, "Provider linked", identity)
+}
+
+// LinkIdentity returns the provider URL to open to link an account to the logged in user
+func LinkIdentity(c *gin.Context) {
+	provider, ok := oidcProvider(c)
+	if !ok {
+		return
+	}
+
+	authURL, err := startOidcFlow(c, provider, currentUserID(c))
+	if err != nil {
+		log.Printf("starting %s linking: %v", provider.Name, err)
+		responses.Error(c, responses.UpstreamUnavailable, "Identity provider unavailable")
+		return
+	}
+
+	responses.Success(c, http.StatusOK, "Open the URL to link the provider", gin.H{"url": authURL})
+}
+
+// ListIdentities returns the providers linked to the logged in user
+func ListIdentities(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	cur, err := identityCollection.Find(ctx, bson.M{"user_id": currentUserID(c)})
+	if err != nil {
+		responses.Error(c, responses.InternalError, "Error fetching identities")
+		return
+	}
+	defer cur.Close(ctx)
+
+	identities := []models.Identity{}
+	if err := cur.All(ctx, &identities); err != nil {
+		responses.Error(c, responses.InternalError, "Error decoding identities")
+		return
+	}
+
+	responses.Success(c, http.StatusOK, "Success", identities)
+}
+
+// UnlinkIdentity removes a provider, unless it is the only way left to sign in
+func UnlinkIdentity(c *gin.Context) {
+	ctx := c.Request.Context()
+	userID := currentUserID(c)
+	provider := c.Param("provider")
+
+	user, ok := findCurrentUser(c)
+	if !ok {
+		return
+	}
+
+	if user.Password == "" {
+		count, err := identityCollection.CountDocuments(ctx, bson.M{"user_id": userID})
+		if err != nil {
+			responses.Error(c, responses.InternalError, "Error unlinking provider")
+			return
+		}
+		if count <= 1 {
+			responses.Error(c, responses.InvalidRequest, "Set a password before unlinking your only sign in method")
+			return
+		}
+	}
+
+	result, err := identityCollection.DeleteOne(ctx, bson.M{"user_id": userID, "provider": provider})
+	if err != nil {
+		responses.Error(c, responses.InternalError, "Error unlinking provider")
+		return
+	}
+	if result.DeletedCount == 0 {
+		responses.Error(c, responses.IdentityNotFound, "Provider not linked")
+		return
+	}
+
+	responses.Success(c, http.StatusOK, "Provider unlinked", nil)
+}
@@ -0,0 +1,75 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// callback calls OidcCallback of the mock provider with the state query and cookie
+func callback(t *testing.T, state, cookie string) *httptest.ResponseRecorder {
+	t.Helper()
+	t.Setenv("OIDC_MOCK", "true")
+	gin.SetMode(gin.TestMode)
+
+	router := gin.New()
+	router.GET("/api/auth/oidc/:provider/callback", OidcCallback)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/auth/oidc/mock/callback?code=code-1&state="+state, nil)
+	if cookie != "" {
+		req.AddCookie(&http.Cookie{Name: oidcStateCookie, Value: cookie})
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+// the callback is refused before the state is looked up, so no database is needed
+func TestOidcCallbackRequiresStateCookie(t *testing.T) {
+	cases := map[string]struct{ state, cookie string }{
+		"no cookie":    {"state-of-attacker", ""},
+		"other cookie": {"state-of-attacker", "state-of-victim"},
+		"no state":     {"", "state-of-victim"},
+		"prefix of it": {"state", "state-of-victim"},
+		"neither":      {"", ""},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			w := callback(t, tc.state, tc.cookie)
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf("status %d, body %s", w.Code, w.Body)
+			}
+
+			var body struct {
+				Code string `json:"code"`
+			}
+			json.Unmarshal(w.Body.Bytes(), &body)
+			if body.Code != "INVALID_TOKEN" {
+				t.Fatalf("body %s", w.Body)
+			}
+		})
+	}
+}
+
+func TestOidcStateCookie(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/api/auth/oidc/mock", nil)
+
+	setOidcStateCookie(c, "state-1", 600)
+
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("cookies %v", cookies)
+	}
+	cookie := cookies[0]
+	if cookie.Name != oidcStateCookie || cookie.Value != "state-1" || !cookie.HttpOnly ||
+		cookie.SameSite != http.SameSiteLaxMode || cookie.Path != "/api/auth/oidc" {
+		t.Fatalf("cookie %+v", cookie)
+	}
+}
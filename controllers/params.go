@@ -0,0 +1,18 @@
+package controllers
+
+import (
+	"gin-api/models"
+	"gin-api/responses"
+
+	"github.com/gin-gonic/gin"
+)
+
+// bindID returns the :id path parameter, writing a validation error when it is not a UUID
+func bindID(c *gin.Context) (string, bool) {
+	var param models.IDParam
+	if err := c.ShouldBindUri(&param); err != nil {
+		responses.Validation(c, err)
+		return "", false
+	}
+	return param.ID, true
+}
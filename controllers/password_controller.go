@@ -0,0 +1,177 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"gin-api/helpers"
+	"gin-api/models"
+	"gin-api/responses"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const resetPasswordExpiration = time.Hour
+
+// setPassword stores the new password and revokes every token of the user
+func setPassword(c *gin.Context, userID, password string) error {
+	hash, err := HashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	ctx := c.Request.Context()
+	now := time.Now()
+	update := bson.M{
+		"$set": bson.M{"password": hash, "updated_at": now},
+		"$inc": bson.M{"version": 1, "token_version": 1},
+	}
+	before, after, err := findAndUpdate(ctx, userCollection, bson.M{"_id": userID}, update, nil)
+	if err != nil {
+		return err
+	}
+
+	// a reset link is sent to the owner, so an anonymous change is theirs
+	entry := auditActor(c)
+	if entry.Actor_id == "" {
+		entry.Actor_id = userID
+	}
+	entry.Action = models.AuditUpdate
+	entry.Entity_type = entityUser
+	entry.Entity_id = userID
+	insertAudit(ctx, entry, before, after)
+
+	// the sessions are already refused because of token_version, this keeps the list accurate
+	if err := revokeSessions(ctx, bson.M{"user_id": userID}); err != nil {
+		return err
+	}
+
+	// pending reset links must not work once the password changed
+	_, err = tokenCollection.DeleteMany(ctx, bson.M{"user_id": userID, "purpose": models.TokenResetPassword})
+	return err
+}
+
+func sendResetPasswordEmail(user models.User) {
+	ctx := context.Background()
+
+	allowed, err := canResend(ctx, user.ID, models.TokenResetPassword)
+	if err != nil || !allowed {
+		return
+	}
+
+	token, err := issueUserToken(ctx, user.ID, models.TokenResetPassword, resetPasswordExpiration)
+	if err != nil {
+		log.Printf("issuing reset token for %s: %v", user.ID, err)
+		return
+	}
+
+	link := helpers.AppURL() + "/reset-password?token=" + url.QueryEscape(token)
+	body := fmt.Sprintf("Hi %s,\n\nUse the link below within an hour to choose a new password:\n\n%s\n\nIf you did not ask for this, you can ignore this email.\n", user.Username, link)
+
+	if err := mailer.Send(ctx, user.Email, "Reset your password", body); err != nil {
+		log.Printf("sending reset email to %s: %v", user.Email, err)
+	}
+}
+
+// ForgotPassword emails a reset link. The response is the same whether or not the
+// username exists, and the email is sent in the background so timing does not tell either.
+func ForgotPassword(c *gin.Context) {
+	var request models.ForgotPasswordRequest
+	if err := c.ShouldBind(&request); err != nil {
+		responses.Validation(c, err)
+		return
+	}
+
+	var user models.User
+	err := userCollection.FindOne(c.Request.Context(), bson.M{"username": request.Username}).Decode(&user)
+	if err == nil && user.Email != "" {
+		go sendResetPasswordEmail(user)
+	} else if err != nil && err != mongo.ErrNoDocuments {
+		log.Printf("finding user for password reset: %v", err)
+	}
+
+	responses.Success(c, http.StatusOK, "If the account exists, a password reset email has been sent", nil)
+}
+
+// ResetPassword sets a new password with an emailed token
+func ResetPassword(c *gin.Context) {
+	var request models.ResetPasswordRequest
+	if err := c.ShouldBind(&request); err != nil {
+		responses.Validation(c, err)
+		return
+	}
+
+	// the password is checked against the account before the link is used up,
+	// a refused password leaves it valid for another try
+	ctx := c.Request.Context()
+	user, err := userTokenOwner(ctx, request.Token, models.TokenResetPassword)
+	if err == errInvalidUserToken {
+		responses.Error(c, responses.InvalidToken, "Invalid or expired reset link")
+		return
+	} else if err != nil {
+		responses.Error(c, responses.InternalError, "Error resetting password")
+		return
+	}
+	if err := helpers.ValidatePassword(request.Password, user.Username, user.Email); err != nil {
+		responses.Error(c, responses.PasswordPolicy, err.Error())
+		return
+	}
+
+	userID, err := consumeUserToken(ctx, request.Token, models.TokenResetPassword)
+	if err == errInvalidUserToken {
+		responses.Error(c, responses.InvalidToken, "Invalid or expired reset link")
+		return
+	} else if err != nil {
+		responses.Error(c, responses.InternalError, "Error resetting password")
+		return
+	}
+
+	if err := setPassword(c, userID, request.Password); err != nil {
+		responses.Error(c, responses.InternalError, "Error resetting password")
+		return
+	}
+
+	responses.Success(c, http.StatusOK, "Password reset, please sign in again", nil)
+}
+
+// ChangePassword sets a new password for the logged in user after checking the current one
+func ChangePassword(c *gin.Context) {
+	var request models.ChangePasswordRequest
+	if err := c.ShouldBind(&request); err != nil {
+		responses.Validation(c, err)
+		return
+	}
+
+	ctx := c.Request.Context()
+	var user models.User
+	err := userCollection.FindOne(ctx, bson.M{"_id": currentUserID(c)}).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		responses.Error(c, responses.UserNotFound, "User not found")
+		return
+	} else if err != nil {
+		responses.Error(c, responses.InternalError, "Error fetching user")
+		return
+	}
+
+	if !VerifyPassword(request.CurrentPassword, user.Password) {
+		responses.Error(c, responses.Unauthorized, "Current password is incorrect")
+		return
+	}
+
+	if err := helpers.ValidatePassword(request.NewPassword, user.Username, user.Email); err != nil {
+		responses.Error(c, responses.PasswordPolicy, err.Error())
+		return
+	}
+
+	if err := setPassword(c, user.ID, request.NewPassword); err != nil {
+		responses.Error(c, responses.InternalError, "Error changing password")
+		return
+	}
+
+	responses.Success(c, http.StatusOK, "Password changed, please sign in again", nil)
+}
@@ -0,0 +1,120 @@
+package controllers
+
+import (
+	"encoding/json"
+	"gin-api/responses"
+	"gin-api/validation"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// fields a patch can never change, they are set by the server
+var readonlyFields = map[string]bool{"id": true, "_id": true, "created_at": true, "updated_at": true}
+
+// bindMergePatch reads a JSON Merge Patch (RFC 7396), or the sparse fields of a
+// form, into patch: a struct of pointer fields tagged with their json and bson
+// names. Only the provided fields end up in set. A field set to null, or sent
+// empty in a form, ends up in unset when it is nullable and is refused otherwise.
+func bindMergePatch(c *gin.Context, patch any, nullable ...string) (set, unset bson.M, ok bool) {
+	fields, err := patchFields(c)
+	if err != nil {
+		responses.Validation(c, err)
+		return nil, nil, false
+	}
+
+	target := reflect.ValueOf(patch).Elem()
+	known := map[string]reflect.StructField{}
+	for i := 0; i < target.NumField(); i++ {
+		field := target.Type().Field(i)
+		known[tagName(field, "json")] = field
+	}
+
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	language := c.GetHeader("Accept-Language")
+	var invalid []responses.FieldError
+	unset = bson.M{}
+	for _, name := range names {
+		field, isKnown := known[name]
+		switch {
+		case readonlyFields[name]:
+			invalid = append(invalid, validation.Field(language, name, "readonly"))
+		case !isKnown:
+			invalid = append(invalid, validation.Field(language, name, "unknown"))
+		case string(fields[name]) == "null" && !slices.Contains(nullable, name):
+			invalid = append(invalid, validation.Field(language, name, "required"))
+		case string(fields[name]) == "null":
+			unset[tagName(field, "bson")] = ""
+		}
+	}
+	if len(invalid) > 0 {
+		responses.ValidationFields(c, invalid)
+		return nil, nil, false
+	}
+
+	body, err := json.Marshal(fields)
+	if err == nil {
+		err = json.Unmarshal(body, patch)
+	}
+	if err == nil {
+		err = binding.Validator.ValidateStruct(patch)
+	}
+	if err != nil {
+		responses.Validation(c, err)
+		return nil, nil, false
+	}
+
+	set = bson.M{}
+	for i := 0; i < target.NumField(); i++ {
+		if value := target.Field(i); !value.IsNil() {
+			set[tagName(target.Type().Field(i), "bson")] = value.Elem().Interface()
+		}
+	}
+	return set, unset, true
+}
+
+// patchFields returns the raw value of every field sent, form values become JSON strings
+func patchFields(c *gin.Context) (map[string]json.RawMessage, error) {
+	var values map[string][]string
+	switch c.ContentType() {
+	case binding.MIMEPOSTForm:
+		if err := c.Request.ParseForm(); err != nil {
+			return nil, err
+		}
+		values = c.Request.PostForm
+	case binding.MIMEMultipartPOSTForm:
+		form, err := c.MultipartForm()
+		if err != nil {
+			return nil, err
+		}
+		values = form.Value
+	default:
+		fields := map[string]json.RawMessage{}
+		err := json.NewDecoder(c.Request.Body).Decode(&fields)
+		return fields, err
+	}
+
+	fields := make(map[string]json.RawMessage, len(values))
+	for name, value := range values {
+		if len(value) == 0 || value[0] == "" {
+			fields[name] = json.RawMessage("null")
+			continue
+		}
+		fields[name], _ = json.Marshal(value[0])
+	}
+	return fields, nil
+}
+
+func tagName(field reflect.StructField, tag string) string {
+	return strings.SplitN(field.Tag.Get(tag), ",", 2)[0]
+}
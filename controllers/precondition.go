@@ -0,0 +1,77 @@
+package controllers
+
+import (
+	"gin-api/responses"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Products, categories and users carry a version that every write increments.
+// It is sent as the ETag, e.g. "3", and writes must name it in If-Match so two
+// clients editing the same document cannot overwrite each other. Documents
+// written before versioning have no version and count as version 0.
+
+// IF_MATCH_OPTIONAL=true accepts writes without an If-Match header
+var ifMatchOptional = os.Getenv("IF_MATCH_OPTIONAL") == "true"
+
+// setETag sets the ETag of the returned version
+func setETag(c *gin.Context, version int64) {
+	c.Header("ETag", `"`+strconv.FormatInt(version, 10)+`"`)
+}
+
+// ifMatch adds the versions named by the If-Match header to filter, so the
+// write only applies to them. It writes the error and returns false when the
+// header is required but missing.
+func ifMatch(c *gin.Context, filter bson.M) bool {
+	header := strings.TrimSpace(c.GetHeader("If-Match"))
+	if header == "" && ifMatchOptional || header == "*" {
+		return true
+	}
+	if header == "" {
+		responses.Error(c, responses.PreconditionRequired, "If-Match header is required, send the ETag of the resource")
+		return false
+	}
+
+	// tags that are not a version never match
+	versions := []interface{}{}
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		version, err := strconv.ParseInt(strings.Trim(tag, `"`), 10, 64)
+		if err != nil {
+			continue
+		}
+		versions = append(versions, version)
+		if version == 0 {
+			versions = append(versions, nil)
+		}
+	}
+	filter["version"] = bson.M{"$in": versions}
+	return true
+}
+
+// missedWrite responds to a conditional write whose filter matched nothing:
+// the document either changed since it was read or does not exist
+func missedWrite(c *gin.Context, collection *mongo.Collection, filter bson.M, notFound responses.ErrorCode, message string) {
+	exists := bson.M{}
+	for key, value := range filter {
+		if key != "version" {
+			exists[key] = value
+		}
+	}
+
+	count, err := collection.CountDocuments(c.Request.Context(), exists)
+	if err != nil {
+		responses.Error(c, responses.InternalError, "Error checking version")
+		return
+	}
+	if count > 0 {
+		responses.Error(c, responses.PreconditionFailed, "Resource was changed, read it again and retry")
+		return
+	}
+	responses.Error(c, notFound, message)
+}
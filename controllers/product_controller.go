@@ -2,11 +2,12 @@ package controllers
 
 import (
 	"context"
-	"fmt"
 	"gin-api/configs"
 	"gin-api/helpers"
 	"gin-api/models"
+	"gin-api/responses"
 	"net/http"
+	"regexp"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -33,46 +34,60 @@ func CreateProduct(c *gin.Context) {
 
 	// Use ShouldBind instead of ShouldBindJSON for form data
 	if err := c.ShouldBind(&product); err != nil {
-		// Use StatusJSON to set both HTTP status and JSON response
-		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid form data: %s", err.Error())})
+		responses.Validation(c, err)
+		return
+	}
+
+	if !skuAvailable(c, product.Sku, "") || !categoriAvailable(c, product.CategoriID) {
 		return
 	}
 
 	// Assign other fields and generate ID
 	product.ID = uuid.New().String()
+	product.Version = 1
 	product.CreatedAt = time.Now()
 	product.UpdatedAt = time.Now()
 	// Upload gambar ke MinIO
-	err := helpers.UploadImageToMinio(product.Image, product.Image.Filename)
+	objectName, err := helpers.UploadContentAddressedImage(product.Image, helpers.CatalogPrefix)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error uploading image to MinIO"})
+		responses.Error(c, responses.InternalError, "Error uploading image to MinIO")
 		return
 	}
+	product.Image.Filename = objectName
 	// Insert the product into the database
 	_, err = productCollection.InsertOne(context.Background(), product)
-	if err != nil {
+	if mongo.IsDuplicateKeyError(err) {
+		// the sku was taken since skuAvailable checked it
+		responses.Error(c, responses.SkuExists, "SKU already exists")
+		return
+	} else if err != nil {
 		// Use StatusJSON for consistent response format
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error creating product"})
+		responses.Error(c, responses.InternalError, "Error creating product")
 		return
 	}
+	recordAudit(c, models.AuditCreate, entityProduct, product.ID, nil, product)
+	recordProductRevision(c.Request.Context(), currentUserID(c), toDocument(product), 0)
 
 	result := gin.H{
-		"id":         product.ID,
-		"name":       product.Name,
-		"image":      product.Image.Filename,
-		"price":      product.Price,
-		"desc":       product.Desc,
-		"stock":      product.Stock,
-		"weight":     product.Weight,
-		"created_at": product.CreatedAt,
-		"update_at":  product.UpdatedAt,
+		"id":          product.ID,
+		"name":        product.Name,
+		"sku":         product.Sku,
+		"slug":        product.Slug,
+		"image":       product.Image.Filename,
+		"price":       product.Price,
+		"currency":    product.Currency,
+		"desc":        product.Desc,
+		"stock":       product.Stock,
+		"weight":      product.Weight,
+		"categori_id": product.CategoriID,
+		"version":     product.Version,
+		"created_at":  product.CreatedAt,
+		"update_at":   product.UpdatedAt,
 	}
 
 	// Use StatusJSON for consistent response format
-	c.JSON(http.StatusCreated, gin.H{
-		"message": "Product created",
-		"data":    result,
-	})
+	setETag(c, product.Version)
+	responses.Success(c, http.StatusCreated, "Product created", result)
 }
 
 // ref: https://swaggo.github.io/swaggo.io/declarative_comments_format/api_operation.html
@@ -86,39 +101,33 @@ func CreateProduct(c *gin.Context) {
 // @Failure 400 {object} model.HTTPError
 // @Router /accounts/{id} [get]
 func AllProduct(c *gin.Context) {
+	var query models.ProductQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		responses.Validation(c, err)
+		return
+	}
+
 	var products []models.Product
 
-	cur, err := productCollection.Find(context.Background(), bson.D{})
+	cur, err := productCollection.Find(context.Background(), productFilter(query))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching products"})
+		responses.Error(c, responses.InternalError, "Error fetching products")
 		return
 	}
 	defer cur.Close(context.Background())
 
 	if err := cur.All(context.Background(), &products); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error decoding products"})
+		responses.Error(c, responses.InternalError, "Error decoding products")
 		return
 	}
 
 	var result []gin.H
 
 	for _, v := range products {
-		data := gin.H{
-			"id":     v.ID,
-			"name":   v.Name,
-			"image":  v.Image.Filename,
-			"price":  v.Price,
-			"desc":   v.Desc,
-			"stock":  v.Stock,
-			"weight": v.Weight,
-		}
-		result = append(result, data)
+		result = append(result, productResult(v))
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"message": "Get All Products",
-		"data":    result,
-	})
+	responses.Success(c, http.StatusOK, "Get All Products", result)
 }
 
 // ref: https://swaggo.github.io/swaggo.io/declarative_comments_format/api_operation.html
@@ -132,35 +141,26 @@ func AllProduct(c *gin.Context) {
 // @Failure 400 {object} model.HTTPError
 // @Router /accounts/{id} [get]
 func OneProduct(c *gin.Context) {
-	productID := c.Param("id")
+	productID, ok := bindID(c)
+	if !ok {
+		return
+	}
 
-	filter := bson.M{"_id": productID}
+	filter := bson.M{"_id": productID, "deleted_at": bson.M{"$exists": false}}
 	var products models.Product
 	err := productCollection.FindOne(context.Background(), filter).Decode(&products)
 
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
-			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			responses.Error(c, responses.ProductNotFound, "Product not found")
 			return
 		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching product"})
+		responses.Error(c, responses.InternalError, "Error fetching product")
 		return
 	}
 
-	result := gin.H{
-		"id":     products.ID,
-		"name":   products.Name,
-		"image":  products.Image.Filename,
-		"price":  products.Price,
-		"desc":   products.Desc,
-		"stock":  products.Stock,
-		"weight": products.Weight,
-	}
-
-	c.JSON(http.StatusOK, gin.H{
-		"message": "Get One Product",
-		"data":    result,
-	})
+	setETag(c, products.Version)
+	responses.Success(c, http.StatusOK, "Get One Product", productResult(products))
 }
 
 // ref: https://swaggo.github.io/swaggo.io/declarative_comments_format/api_operation.html
@@ -174,56 +174,220 @@ func OneProduct(c *gin.Context) {
 // @Failure 400 {object} model.HTTPError
 // @Router /accounts/{id} [get]
 func UpdateProduct(c *gin.Context) {
-	productID := c.Param("id")
+	productID, ok := bindID(c)
+	if !ok {
+		return
+	}
 
-	// Convert productID to UUID
-	uuid, err := uuid.Parse(productID)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
+	filter := bson.M{"_id": productID, "deleted_at": bson.M{"$exists": false}}
+	if !ifMatch(c, filter) {
 		return
 	}
 
 	var product models.UpdateProductRequest
 	if err := c.ShouldBind(&product); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		responses.Validation(c, err)
 		return
 	}
 
-	// Set the ID field with the parsed UUID
-	product.ID = uuid.String()
+	if !skuAvailable(c, product.Sku, productID) {
+		return
+	}
 
-	filter := bson.M{"_id": product.ID}
-	update := bson.M{"$set": product}
+	product.ID = productID
+	product.UpdatedAt = time.Now()
 
 	// Upload image to MinIO
+	var oldImage string
+	var err error
 	if product.Image != nil {
-		err := helpers.UploadImageToMinio(product.Image, product.Image.Filename)
+		oldImage, err = storedImage(context.TODO(), productCollection, product.ID)
+		if err != nil && err != mongo.ErrNoDocuments {
+			responses.Error(c, responses.InternalError, "Error fetching product")
+			return
+		}
+
+		objectName, err := helpers.UploadContentAddressedImage(product.Image, helpers.CatalogPrefix)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error uploading image to MinIO"})
+			responses.Error(c, responses.InternalError, "Error uploading image to MinIO")
 			return
 		}
+		product.Image.Filename = objectName
 	}
 
-	_, err = productCollection.UpdateOne(context.TODO(), filter, update)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error updating product"})
+	updateProductFields(c, filter, bson.M{"$set": product}, oldImage, 0)
+}
+
+// ref: https://swaggo.github.io/swaggo.io/declarative_comments_format/api_operation.html
+// @Summary Update product fields
+// @Description changes only the fields sent as JSON Merge Patch or form fields, null or empty removes an optional field
+// @Tags products
+// @Accept  json,mpfd
+// @Produce  json
+// @Param id path string true "Product ID"
+// @Success 200 {object} responses.Result
+// @Failure 400 {object} responses.Result
+// @Router /product/updateProduct/{id} [patch]
+func PatchProduct(c *gin.Context) {
+	productID, ok := bindID(c)
+	if !ok {
 		return
 	}
 
-	result := gin.H{
-		"id":     product.ID,
-		"name":   product.Name,
-		"image":  product.Image.Filename,
-		"price":  product.Price,
-		"desc":   product.Desc,
-		"stock":  product.Stock,
-		"weight": product.Weight,
-	}
-
-	c.JSON(http.StatusOK, gin.H{
-		"message": "Product updated",
-		"data":    result,
-	})
+	filter := bson.M{"_id": productID, "deleted_at": bson.M{"$exists": false}}
+	if !ifMatch(c, filter) {
+		return
+	}
+
+	var patch models.ProductPatch
+	set, unset, ok := bindMergePatch(c, &patch, "sku", "slug", "currency", "desc", "stock", "weight", "categori_id")
+	if !ok {
+		return
+	}
+	if sku, ok := set["sku"].(string); ok && !skuAvailable(c, sku, productID) {
+		return
+	}
+	if categoriID, ok := set["categori_id"].(string); ok && !categoriAvailable(c, categoriID) {
+		return
+	}
+
+	oldImage, err := storedImage(context.TODO(), productCollection, productID)
+	if err == mongo.ErrNoDocuments {
+		responses.Error(c, responses.ProductNotFound, "Product not found")
+		return
+	} else if err != nil {
+		responses.Error(c, responses.InternalError, "Error fetching product")
+		return
+	}
+
+	// a multipart patch may replace the image
+	if image, err := c.FormFile("image"); err == nil {
+		objectName, err := helpers.UploadContentAddressedImage(image, helpers.CatalogPrefix)
+		if err != nil {
+			responses.Error(c, responses.InternalError, "Error uploading image to MinIO")
+			return
+		}
+		image.Filename = objectName
+		set["image"] = image
+	}
+
+	set["updatedat"] = time.Now()
+	update := bson.M{"$set": set}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+	updateProductFields(c, filter, update, oldImage, 0)
+}
+
+// updateProductFields applies update to the product matching filter, bumps its
+// version, stores a revision and responds with the stored product. oldImage is
+// removed when the update replaced it and no revision references it anymore.
+// rollbackOf is the restored version when the update is a rollback.
+func updateProductFields(c *gin.Context, filter, update bson.M, oldImage string, rollbackOf int64) {
+	update["$inc"] = bson.M{"version": 1}
+
+	var product models.Product
+	before, after, err := findAndUpdate(context.TODO(), productCollection, filter, update, &product)
+	if err == mongo.ErrNoDocuments {
+		missedWrite(c, productCollection, filter, responses.ProductNotFound, "Product not found")
+		return
+	} else if mongo.IsDuplicateKeyError(err) {
+		responses.Error(c, responses.SkuExists, "SKU already exists")
+		return
+	} else if err != nil {
+		responses.Error(c, responses.InternalError, "Error updating product")
+		return
+	}
+	recordAudit(c, models.AuditUpdate, entityProduct, product.ID, before, after)
+	recordProductRevision(c.Request.Context(), currentUserID(c), after, rollbackOf)
+
+	// Remove the replaced image
+	if product.Image != nil && oldImage != product.Image.Filename {
+		removeImageIfOrphaned(context.TODO(), oldImage)
+	}
+
+	setETag(c, product.Version)
+	responses.Success(c, http.StatusOK, "Product updated", productResult(product))
+}
+
+// productFilter selects the products outside of the trash matching the query
+func productFilter(query models.ProductQuery) bson.M {
+	filter := bson.M{"deleted_at": bson.M{"$exists": false}}
+	if query.Q != "" {
+		pattern := bson.M{"$regex": regexp.QuoteMeta(query.Q), "$options": "i"}
+		filter["$or"] = bson.A{bson.M{"name": pattern}, bson.M{"sku": pattern}, bson.M{"slug": pattern}}
+	}
+	if query.Currency != "" {
+		filter["currency"] = query.Currency
+	}
+	if query.Categori_id != "" {
+		filter["categori_id"] = query.Categori_id
+	}
+	if !query.Updated_since.IsZero() {
+		filter["updatedat"] = bson.M{"$gte": query.Updated_since}
+	}
+	return filter
+}
+
+// skuAvailable responds with a conflict when another product, trashed ones
+// included, already has the sku. An empty sku is always available.
+func skuAvailable(c *gin.Context, sku, productID string) bool {
+	if sku == "" {
+		return true
+	}
+
+	count, err := productCollection.CountDocuments(c.Request.Context(), bson.M{"sku": sku, "_id": bson.M{"$ne": productID}})
+	if err != nil {
+		responses.Error(c, responses.InternalError, "Error fetching product")
+		return false
+	}
+	if count > 0 {
+		responses.Error(c, responses.SkuExists, "SKU already exists")
+		return false
+	}
+	return true
+}
+
+// categoriAvailable responds with a not found error unless a categori outside
+// of the trash has the id. An empty id is always available.
+func categoriAvailable(c *gin.Context, categoriID string) bool {
+	if categoriID == "" {
+		return true
+	}
+
+	count, err := categoriCollection.CountDocuments(c.Request.Context(), bson.M{"_id": categoriID, "deleted_at": bson.M{"$exists": false}})
+	if err != nil {
+		responses.Error(c, responses.InternalError, "Error fetching category")
+		return false
+	}
+	if count == 0 {
+		responses.Error(c, responses.CategoryNotFound, "Category not found")
+		return false
+	}
+	return true
+}
+
+// productResult is the product returned by the read and update endpoints
+func productResult(product models.Product) gin.H {
+	var image string
+	if product.Image != nil {
+		image = product.Image.Filename
+	}
+
+	return gin.H{
+		"id":          product.ID,
+		"name":        product.Name,
+		"sku":         product.Sku,
+		"slug":        product.Slug,
+		"image":       image,
+		"price":       product.Price,
+		"currency":    product.Currency,
+		"desc":        product.Desc,
+		"stock":       product.Stock,
+		"weight":      product.Weight,
+		"categori_id": product.Categori_id,
+		"version":     product.Version,
+	}
 }
 
 // ref: https://swaggo.github.io/swaggo.io/declarative_comments_format/api_operation.html
@@ -237,21 +401,28 @@ func UpdateProduct(c *gin.Context) {
 // @Failure 400 {object} model.HTTPError
 // @Router /accounts/{id} [get]
 func DeleteProduct(c *gin.Context) {
-	productID := c.Param("id")
+	productID, ok := bindID(c)
+	if !ok {
+		return
+	}
+
+	filter := bson.M{"_id": productID, "deleted_at": bson.M{"$exists": false}}
+	if !ifMatch(c, filter) {
+		return
+	}
 
-	filter := bson.M{"_id": productID}
-	result, err := productCollection.DeleteOne(context.Background(), filter)
+	// the product moves to the trash, it is purged with its image after the retention period
+	before, after, err := findAndUpdate(context.Background(), productCollection, filter, trashUpdate(c), nil)
 	if err == mongo.ErrNoDocuments {
-		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		missedWrite(c, productCollection, filter, responses.ProductNotFound, "Product not found")
 		return
 	} else if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error deleting product"})
+		responses.Error(c, responses.InternalError, "Error deleting product")
 		return
 	}
+	recordAudit(c, models.AuditDelete, entityProduct, productID, before, after)
 
-	c.JSON(http.StatusOK, gin.H{
-		"message": fmt.Sprintf("User deleted. %d documents deleted", result.DeletedCount),
-	})
+	responses.Success(c, http.StatusOK, "Product deleted", nil)
 }
 
 // ref: https://swaggo.github.io/swaggo.io/declarative_comments_format/api_operation.html
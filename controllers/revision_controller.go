@@ -0,0 +1,251 @@
+package controllers
+
+import (
+	"context"
+	"gin-api/configs"
+	"gin-api/models"
+	"gin-api/responses"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// revisions are only inserted, they are deleted with their product when the trash is purged
+var productRevisionCollection *mongo.Collection = configs.GetCollection(configs.DB, "product_revisions")
+
+// the stored product fields kept by a revision and restored by a rollback
+var revisedProductFields = []string{"name", "sku", "slug", "image", "price", "currency", "desc", "stock", "weight", "categori_id"}
+
+func productSnapshot(doc bson.M) map[string]interface{} {
+	snapshot := map[string]interface{}{}
+	for _, field := range revisedProductFields {
+		if value, ok := doc[field]; ok {
+			snapshot[field] = value
+		}
+	}
+	return snapshot
+}
+
+// recordProductRevision stores the content of the product document doc as a
+// new revision. Failures are only logged, the product is already stored.
+func recordProductRevision(ctx context.Context, authorID string, doc bson.M, rollbackOf int64) {
+	productID, _ := doc["_id"].(string)
+	version, _ := doc["version"].(int64)
+	revision := models.ProductRevision{
+		ID:          uuid.New().String(),
+		Product_id:  productID,
+		Version:     version,
+		Snapshot:    productSnapshot(doc),
+		Author_id:   authorID,
+		Rollback_of: rollbackOf,
+		Created_at:  time.Now(),
+	}
+
+	if _, err := productRevisionCollection.InsertOne(ctx, revision); err != nil {
+		log.Printf("recording revision %d of product %s: %v", version, productID, err)
+	}
+}
+
+func findRevision(ctx context.Context, productID string, version int64) (models.ProductRevision, error) {
+	var revision models.ProductRevision
+	err := productRevisionCollection.FindOne(ctx, bson.M{"product_id": productID, "version": version}).Decode(&revision)
+	return revision, err
+}
+
+// revisionProduct decodes the snapshot of a revision
+func revisionProduct(revision models.ProductRevision) (models.Product, error) {
+	var product models.Product
+	data, err := bson.Marshal(revision.Snapshot)
+	if err != nil {
+		return product, err
+	}
+	if err := bson.Unmarshal(data, &product); err != nil {
+		return product, err
+	}
+
+	product.ID = revision.Product_id
+	product.Version = revision.Version
+	return product, nil
+}
+
+func revisionResult(revision models.ProductRevision, product models.Product) gin.H {
+	return gin.H{
+		"id":          revision.ID,
+		"product_id":  revision.Product_id,
+		"version":     revision.Version,
+		"author_id":   revision.Author_id,
+		"rollback_of": revision.Rollback_of,
+		"created_at":  revision.Created_at,
+		"product":     productResult(product),
+	}
+}
+
+// deleteProductRevisions removes the revisions of a purged product and
+// returns the images they referenced
+func deleteProductRevisions(ctx context.Context, productID string) ([]string, error) {
+	names, err := productRevisionCollection.Distinct(ctx, "snapshot.image.filename", bson.M{"product_id": productID})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := productRevisionCollection.DeleteMany(ctx, bson.M{"product_id": productID}); err != nil {
+		return nil, err
+	}
+
+	images := []string{}
+	for _, name := range names {
+		if s, ok := name.(string); ok {
+			images = append(images, s)
+		}
+	}
+	return images, nil
+}
+
+// productExists tells whether a product outside of the trash has the given id
+func productExists(c *gin.Context, productID string) bool {
+	count, err := productCollection.CountDocuments(c.Request.Context(), bson.M{"_id": productID, "deleted_at": bson.M{"$exists": false}})
+	if err != nil {
+		responses.Error(c, responses.InternalError, "Error fetching product")
+		return false
+	}
+	if count == 0 {
+		responses.Error(c, responses.ProductNotFound, "Product not found")
+		return false
+	}
+	return true
+}
+
+// ListProductRevisions lists the revisions of a product, newest first
+func ListProductRevisions(c *gin.Context) {
+	productID, ok := bindID(c)
+	if !ok {
+		return
+	}
+	if !productExists(c, productID) {
+		return
+	}
+
+	ctx := c.Request.Context()
+	opts := options.Find().SetSort(bson.M{"version": -1})
+	cur, err := productRevisionCollection.Find(ctx, bson.M{"product_id": productID}, opts)
+	if err != nil {
+		responses.Error(c, responses.InternalError, "Error fetching revisions")
+		return
+	}
+	defer cur.Close(ctx)
+
+	var revisions []models.ProductRevision
+	if err := cur.All(ctx, &revisions); err != nil {
+		responses.Error(c, responses.InternalError, "Error decoding revisions")
+		return
+	}
+
+	result := []gin.H{}
+	for _, revision := range revisions {
+		product, err := revisionProduct(revision)
+		if err != nil {
+			responses.Error(c, responses.InternalError, "Error decoding revisions")
+			return
+		}
+		result = append(result, revisionResult(revision, product))
+	}
+
+	responses.Success(c, http.StatusOK, "Get Product Revisions", result)
+}
+
+// DiffProductRevisions compares two revisions of a product field by field
+func DiffProductRevisions(c *gin.Context) {
+	productID, ok := bindID(c)
+	if !ok {
+		return
+	}
+	var query models.RevisionDiffQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		responses.Validation(c, err)
+		return
+	}
+	if !productExists(c, productID) {
+		return
+	}
+
+	var products [2]models.Product
+	for i, version := range []int64{query.From, query.To} {
+		revision, err := findRevision(c.Request.Context(), productID, version)
+		if err == mongo.ErrNoDocuments {
+			responses.Error(c, responses.RevisionNotFound, "Revision not found")
+			return
+		} else if err != nil {
+			responses.Error(c, responses.InternalError, "Error fetching revision")
+			return
+		}
+		if products[i], err = revisionProduct(revision); err != nil {
+			responses.Error(c, responses.InternalError, "Error decoding revision")
+			return
+		}
+	}
+
+	// compared as returned by the API, the image by its object name
+	changes := diffDocuments(bson.M(productResult(products[0])), bson.M(productResult(products[1])))
+	delete(changes, "version")
+
+	responses.Success(c, http.StatusOK, "Get Product Revision Diff", gin.H{
+		"product_id": productID,
+		"from":       query.From,
+		"to":         query.To,
+		"changes":    changes,
+	})
+}
+
+// RollbackProduct restores the content of an old revision, image included.
+// The rollback is a change like any other and creates a new revision.
+func RollbackProduct(c *gin.Context) {
+	var param models.RevisionParam
+	if err := c.ShouldBindUri(&param); err != nil {
+		responses.Validation(c, err)
+		return
+	}
+
+	filter := bson.M{"_id": param.ID, "deleted_at": bson.M{"$exists": false}}
+	if !ifMatch(c, filter) {
+		return
+	}
+
+	revision, err := findRevision(c.Request.Context(), param.ID, param.Version)
+	if err == mongo.ErrNoDocuments {
+		responses.Error(c, responses.RevisionNotFound, "Revision not found")
+		return
+	} else if err != nil {
+		responses.Error(c, responses.InternalError, "Error fetching revision")
+		return
+	}
+
+	if sku, ok := revision.Snapshot["sku"].(string); ok && !skuAvailable(c, sku, param.ID) {
+		return
+	}
+
+	oldImage, err := storedImage(c.Request.Context(), productCollection, param.ID)
+	if err != nil && err != mongo.ErrNoDocuments {
+		responses.Error(c, responses.InternalError, "Error fetching product")
+		return
+	}
+
+	set := bson.M{"updatedat": time.Now()}
+	unset := bson.M{}
+	for _, field := range revisedProductFields {
+		if value, ok := revision.Snapshot[field]; ok {
+			set[field] = value
+		} else {
+			unset[field] = ""
+		}
+	}
+	update := bson.M{"$set": set}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+	updateProductFields(c, filter, update, oldImage, revision.Version)
+}
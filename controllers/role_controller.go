@@ -4,6 +4,7 @@ import (
 	"context"
 	"gin-api/configs"
 	"gin-api/models"
+	"gin-api/responses"
 	"net/http"
 	"time"
 
@@ -18,7 +19,7 @@ var roleCollection *mongo.Collection = configs.GetCollection(configs.DB, "roles"
 func CreateRole(c *gin.Context) {
 	var role models.Role
 	if err := c.ShouldBindJSON(&role); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON format"})
+		responses.Validation(c, err)
 		return
 	}
 
@@ -28,40 +29,32 @@ func CreateRole(c *gin.Context) {
 
 	_, err := roleCollection.InsertOne(context.Background(), role)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error creating role"})
+		responses.Error(c, responses.InternalError, "Error creating role")
 		return
 	}
+	recordAudit(c, models.AuditCreate, entityRole, role.ID, nil, role)
 
-	c.JSON(http.StatusCreated, gin.H{
-		"message": "Role created",
-		"data":    role,
-	})
+	responses.Success(c, http.StatusCreated, "Role created", role)
 }
 
 func GetAllRoles(c *gin.Context) {
 	cur, err := roleCollection.Find(context.Background(), bson.D{})
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching users"})
+		responses.Error(c, responses.InternalError, "Error fetching users")
 		return
 	}
 	defer cur.Close(context.Background())
 
 	var roles []models.Role
 	if err := cur.All(context.Background(), &roles); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error decoding roles"})
+		responses.Error(c, responses.InternalError, "Error decoding roles")
 		return
 	}
 
 	if len(roles) == 0 {
-		c.JSON(http.StatusOK, gin.H{
-			"message": "No Data Roles",
-			"data":    []models.Role{}, // Empty slice of models.User
-		})
+		responses.Success(c, http.StatusOK, "No Data Roles", []models.Role{})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"message": "Get Roles",
-		"data":    roles,
-	})
+	responses.Success(c, http.StatusOK, "Get Roles", roles)
 }
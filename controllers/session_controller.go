@@ -0,0 +1,184 @@
+package controllers
+
+import (
+	"context"
+	"gin-api/configs"
+	"gin-api/helpers"
+	"gin-api/middleware"
+	"gin-api/models"
+	"gin-api/responses"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var sessionCollection *mongo.Collection = configs.GetCollection(configs.DB, "sessions")
+
+const loginTokenExpiration = 3 * time.Hour
+
+// createSession records the device of a login and returns the session id
+func createSession(c *gin.Context, userID string, expiresAt time.Time) (string, error) {
+	now := time.Now()
+	session := models.Session{
+		ID:           uuid.New().String(),
+		User_id:      userID,
+		User_agent:   c.Request.UserAgent(),
+		IP:           c.ClientIP(),
+		Created_at:   now,
+		Last_seen_at: now,
+		Expires_at:   expiresAt,
+	}
+	if _, err := sessionCollection.InsertOne(context.Background(), session); err != nil {
+		return "", err
+	}
+	return session.ID, nil
+}
+
+// revokeSessions revokes the active sessions matching the filter. Tokens of a
+// revoked session are refused once the middleware cache expires.
+func revokeSessions(ctx context.Context, filter bson.M) error {
+	filter["revoked_at"] = bson.M{"$exists": false}
+	_, err := sessionCollection.UpdateMany(ctx, filter, bson.M{"$set": bson.M{"revoked_at": time.Now()}})
+	if userID, ok := filter["user_id"].(string); ok {
+		middleware.ForgetUser(userID)
+	}
+	return err
+}
+
+// currentSessionID returns the session of the token set by the auth middleware
+func currentSessionID(c *gin.Context) string {
+	claims, _ := c.Get("userLogin")
+	details, ok := claims.(*helpers.SignedDetails)
+	if !ok {
+		return ""
+	}
+	return details.SessionID
+}
+
+func activeSessions(ctx context.Context, userID string) ([]models.Session, error) {
+	filter := bson.M{
+		"user_id":    userID,
+		"revoked_at": bson.M{"$exists": false},
+		"expires_at": bson.M{"$gt": time.Now()},
+	}
+	opts := options.Find().SetSort(bson.M{"last_seen_at": -1})
+
+	cur, err := sessionCollection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	sessions := []models.Session{}
+	if err := cur.All(ctx, &sessions); err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+// ListMySessions returns the active sessions of the logged in user
+func ListMySessions(c *gin.Context) {
+	sessions, err := activeSessions(c.Request.Context(), currentUserID(c))
+	if err != nil {
+		responses.Error(c, responses.InternalError, "Error fetching sessions")
+		return
+	}
+
+	current := currentSessionID(c)
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == current
+	}
+
+	responses.Success(c, http.StatusOK, "Success", sessions)
+}
+
+// RevokeMySession logs out one session of the logged in user
+func RevokeMySession(c *gin.Context) {
+	id, ok := bindID(c)
+	if !ok {
+		return
+	}
+
+	filter := bson.M{"_id": id, "user_id": currentUserID(c), "revoked_at": bson.M{"$exists": false}}
+	result, err := sessionCollection.UpdateOne(c.Request.Context(), filter, bson.M{"$set": bson.M{"revoked_at": time.Now()}})
+	if err != nil {
+		responses.Error(c, responses.InternalError, "Error revoking session")
+		return
+	}
+	if result.MatchedCount == 0 {
+		responses.Error(c, responses.SessionNotFound, "Session not found")
+		return
+	}
+	middleware.ForgetSession(id)
+
+	responses.Success(c, http.StatusOK, "Session revoked", nil)
+}
+
+// RevokeMyOtherSessions logs out every session of the logged in user but the current one
+func RevokeMyOtherSessions(c *gin.Context) {
+	filter := bson.M{"user_id": currentUserID(c), "_id": bson.M{"$ne": currentSessionID(c)}}
+	if err := revokeSessions(c.Request.Context(), filter); err != nil {
+		responses.Error(c, responses.InternalError, "Error revoking sessions")
+		return
+	}
+
+	responses.Success(c, http.StatusOK, "Other sessions revoked", nil)
+}
+
+// ListUserSessions returns the active sessions of a user
+func ListUserSessions(c *gin.Context) {
+	userID, ok := bindID(c)
+	if !ok {
+		return
+	}
+
+	sessions, err := activeSessions(c.Request.Context(), userID)
+	if err != nil {
+		responses.Error(c, responses.InternalError, "Error fetching sessions")
+		return
+	}
+
+	responses.Success(c, http.StatusOK, "Success", sessions)
+}
+
+// RevokeUserSessions logs a user out everywhere
+func RevokeUserSessions(c *gin.Context) {
+	userID, ok := bindID(c)
+	if !ok {
+		return
+	}
+
+	if err := revokeSessions(c.Request.Context(), bson.M{"user_id": userID}); err != nil {
+		responses.Error(c, responses.InternalError, "Error revoking sessions")
+		return
+	}
+
+	responses.Success(c, http.StatusOK, "Sessions revoked", nil)
+}
+
+// RevokeSession revokes any session
+func RevokeSession(c *gin.Context) {
+	id, ok := bindID(c)
+	if !ok {
+		return
+	}
+
+	filter := bson.M{"_id": id, "revoked_at": bson.M{"$exists": false}}
+	result, err := sessionCollection.UpdateOne(c.Request.Context(), filter, bson.M{"$set": bson.M{"revoked_at": time.Now()}})
+	if err != nil {
+		responses.Error(c, responses.InternalError, "Error revoking session")
+		return
+	}
+	if result.MatchedCount == 0 {
+		responses.Error(c, responses.SessionNotFound, "Session not found")
+		return
+	}
+	middleware.ForgetSession(id)
+
+	responses.Success(c, http.StatusOK, "Session revoked", nil)
+}
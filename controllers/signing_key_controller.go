@@ -0,0 +1,50 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"gin-api/helpers"
+	"gin-api/responses"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// JWKS publishes the public keys verifying the tokens, including retired keys within their overlap
+func JWKS(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	keys, err := helpers.Keys.JWKS(ctx)
+	if err != nil {
+		responses.Error(c, responses.InternalError, "Error loading signing keys")
+		return
+	}
+
+	// verifiers cache the document, a new key is published longer than that before it signs
+	c.Header("Cache-Control", fmt.Sprintf("public, max-age=%d", int(helpers.JWKSMaxAge.Seconds())))
+	// JWT libraries fetch the set and expect RFC 7517 keys at the top level
+	responses.Document(c, http.StatusOK, gin.H{"keys": keys})
+}
+
+// RotateSigningKey publishes a new signing key now, e.g. after a key leaked. It
+// signs from sign_from, once verifiers caching the JWKS have fetched it.
+func RotateSigningKey(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	key, err := helpers.Keys.Rotate(ctx)
+	if err != nil {
+		responses.Error(c, responses.InvalidRequest, err.Error())
+		return
+	}
+
+	responses.Success(c, http.StatusOK, "Signing key rotated", gin.H{
+		"kid":          key.Kid,
+		"alg":          key.Alg,
+		"sign_from":    key.Sign_from,
+		"sign_until":   key.Sign_until,
+		"verify_until": key.Verify_until,
+	})
+}
@@ -0,0 +1,210 @@
+package controllers
+
+import (
+	"context"
+	"gin-api/models"
+	"gin-api/responses"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// deleted products and categories stay in the trash this long before they are purged
+const defaultTrashRetention = 30 * 24 * time.Hour
+
+// trashRetention reads TRASH_RETENTION, e.g. 720h
+func trashRetention() time.Duration {
+	retention, err := time.ParseDuration(os.Getenv("TRASH_RETENTION"))
+	if err != nil || retention <= 0 {
+		return defaultTrashRetention
+	}
+	return retention
+}
+
+// trashUpdate moves a document to the trash on behalf of the logged in user
+func trashUpdate(c *gin.Context) bson.M {
+	return bson.M{
+		"$set": bson.M{"deleted_at": time.Now(), "deleted_by": currentUserID(c)},
+		"$inc": bson.M{"version": 1},
+	}
+}
+
+// restoreUpdate takes a document out of the trash
+func restoreUpdate() bson.M {
+	return bson.M{
+		"$unset": bson.M{"deleted_at": "", "deleted_by": ""},
+		"$inc":   bson.M{"version": 1},
+	}
+}
+
+func trashedResult(result gin.H, deletedAt *time.Time, deletedBy string) gin.H {
+	result["deleted_at"] = deletedAt
+	result["deleted_by"] = deletedBy
+	result["purge_at"] = deletedAt.Add(trashRetention())
+	return result
+}
+
+func findTrash(ctx context.Context, collection *mongo.Collection, results interface{}) error {
+	opts := options.Find().SetSort(bson.M{"deleted_at": -1})
+	cur, err := collection.Find(ctx, bson.M{"deleted_at": bson.M{"$exists": true}}, opts)
+	if err != nil {
+		return err
+	}
+	defer cur.Close(ctx)
+
+	return cur.All(ctx, results)
+}
+
+// ListProductTrash lists the deleted products, newest first
+func ListProductTrash(c *gin.Context) {
+	var products []models.Product
+	if err := findTrash(c.Request.Context(), productCollection, &products); err != nil {
+		responses.Error(c, responses.InternalError, "Error fetching deleted products")
+		return
+	}
+
+	result := []gin.H{}
+	for _, product := range products {
+		result = append(result, trashedResult(productResult(product), product.Deleted_at, product.Deleted_by))
+	}
+
+	responses.Success(c, http.StatusOK, "Get Deleted Products", result)
+}
+
+// RestoreProduct takes a product out of the trash
+func RestoreProduct(c *gin.Context) {
+	productID, ok := bindID(c)
+	if !ok {
+		return
+	}
+
+	filter := bson.M{"_id": productID, "deleted_at": bson.M{"$exists": true}}
+	var product models.Product
+	before, after, err := findAndUpdate(c.Request.Context(), productCollection, filter, restoreUpdate(), &product)
+	if err == mongo.ErrNoDocuments {
+		responses.Error(c, responses.ProductNotFound, "Product not found in trash")
+		return
+	} else if err != nil {
+		responses.Error(c, responses.InternalError, "Error restoring product")
+		return
+	}
+	recordAudit(c, models.AuditRestore, entityProduct, productID, before, after)
+
+	setETag(c, product.Version)
+	responses.Success(c, http.StatusOK, "Product restored", productResult(product))
+}
+
+// ListCategoriTrash lists the deleted categories, newest first
+func ListCategoriTrash(c *gin.Context) {
+	var categories []models.Categori
+	if err := findTrash(c.Request.Context(), categoriCollection, &categories); err != nil {
+		responses.Error(c, responses.InternalError, "Error fetching deleted categories")
+		return
+	}
+
+	result := []gin.H{}
+	for _, categori := range categories {
+		result = append(result, trashedResult(categoriResult(categori), categori.DeletedAt, categori.DeletedBy))
+	}
+
+	responses.Success(c, http.StatusOK, "Get Deleted Categories", result)
+}
+
+// RestoreCategori takes a categori out of the trash
+func RestoreCategori(c *gin.Context) {
+	categoriID, ok := bindID(c)
+	if !ok {
+		return
+	}
+
+	filter := bson.M{"_id": categoriID, "deleted_at": bson.M{"$exists": true}}
+	var categori models.Categori
+	before, after, err := findAndUpdate(c.Request.Context(), categoriCollection, filter, restoreUpdate(), &categori)
+	if err == mongo.ErrNoDocuments {
+		responses.Error(c, responses.CategoryNotFound, "Categori not found in trash")
+		return
+	} else if err != nil {
+		responses.Error(c, responses.InternalError, "Error restoring categori")
+		return
+	}
+	recordAudit(c, models.AuditRestore, entityCategori, categoriID, before, after)
+
+	setETag(c, categori.Version)
+	responses.Success(c, http.StatusOK, "Categori restored", categoriResult(categori))
+}
+
+// purgeTrash deletes the documents of entityType trashed before cutoff, then
+// their images once nothing else references them
+func purgeTrash(ctx context.Context, collection *mongo.Collection, entityType string, cutoff time.Time) (int, error) {
+	cur, err := collection.Find(ctx, bson.M{"deleted_at": bson.M{"$lt": cutoff}})
+	if err != nil {
+		return 0, err
+	}
+	var docs []bson.M
+	if err := cur.All(ctx, &docs); err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	for _, doc := range docs {
+		// the filter is repeated so a document restored in the meantime stays
+		result, err := collection.DeleteOne(ctx, bson.M{"_id": doc["_id"], "deleted_at": bson.M{"$lt": cutoff}})
+		if err != nil {
+			return purged, err
+		}
+		if result.DeletedCount == 0 {
+			continue
+		}
+		purged++
+
+		entityID, _ := doc["_id"].(string)
+		insertAudit(ctx, models.AuditEntry{Actor_id: "system", Action: models.AuditPurge, Entity_type: entityType, Entity_id: entityID}, doc, nil)
+		images := []string{}
+		if image, ok := doc["image"].(bson.M); ok {
+			filename, _ := image["filename"].(string)
+			images = append(images, filename)
+		}
+		if entityType == entityProduct {
+			revisionImages, err := deleteProductRevisions(ctx, entityID)
+			if err != nil {
+				log.Printf("deleting revisions of product %s: %v", entityID, err)
+			}
+			images = append(images, revisionImages...)
+		}
+		for _, filename := range images {
+			removeImageIfOrphaned(ctx, filename)
+		}
+	}
+	return purged, nil
+}
+
+// StartTrashPurger purges the trash every interval until ctx is done
+func StartTrashPurger(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				cutoff := time.Now().Add(-trashRetention())
+				for entityType, collection := range map[string]*mongo.Collection{entityProduct: productCollection, entityCategori: categoriCollection} {
+					purged, err := purgeTrash(ctx, collection, entityType, cutoff)
+					if err != nil {
+						log.Printf("purging trash of %s: %v", collection.Name(), err)
+					}
+					if purged > 0 {
+						log.Printf("purged %d documents from the trash of %s", purged, collection.Name())
+					}
+				}
+			}
+		}
+	}()
+}
@@ -0,0 +1,176 @@
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"gin-api/helpers"
+	"gin-api/models"
+	"gin-api/responses"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const presignExpiration = 15 * time.Minute
+
+// PresignUpload issues a POST policy to upload an image straight to the bucket
+func PresignUpload(c *gin.Context) {
+	var request models.PresignUploadRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		responses.Validation(c, err)
+		return
+	}
+
+	ext, ok := helpers.ImageExtensions[request.ContentType]
+	if !ok {
+		responses.Error(c, responses.UnsupportedContent, "Unsupported content type")
+		return
+	}
+	if request.Size > helpers.MaxUploadSize() {
+		responses.Error(c, responses.FileTooLarge, "File too large")
+		return
+	}
+
+	key := helpers.UploadPrefix + uuid.New().String() + ext
+	url, formData, err := helpers.Blobs.PresignedPost(c.Request.Context(), key, request.ContentType, request.Size, presignExpiration)
+	if err != nil {
+		responses.Error(c, responses.InternalError, "Error creating upload URL")
+		return
+	}
+
+	responses.Success(c, http.StatusCreated, "Upload URL created", models.PresignUploadResponse{
+		Key:       key,
+		URL:       url.String(),
+		FormData:  formData,
+		ExpiresAt: time.Now().Add(presignExpiration),
+	})
+}
+
+// FinalizeUpload checks a direct upload, moves it to its content addressed
+// name and attaches it to the product or categori
+func FinalizeUpload(c *gin.Context) {
+	var request models.FinalizeUploadRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		responses.Validation(c, err)
+		return
+	}
+
+	if !strings.HasPrefix(request.Key, helpers.UploadPrefix) || strings.Contains(request.Key, "..") {
+		responses.Error(c, responses.InvalidRequest, "Invalid upload key")
+		return
+	}
+
+	collection := productCollection
+	if request.Target == "categori" {
+		collection = categoriCollection
+	}
+
+	ctx := c.Request.Context()
+	oldImage, err := storedImage(ctx, collection, request.ID)
+	if err == mongo.ErrNoDocuments {
+		responses.Error(c, responses.NotFound, "Target not found")
+		return
+	} else if err != nil {
+		responses.Error(c, responses.InternalError, "Error fetching target")
+		return
+	}
+
+	info, err := helpers.Blobs.Stat(ctx, request.Key)
+	if errors.Is(err, helpers.ErrBlobNotFound) {
+		responses.Error(c, responses.UploadNotFound, "Upload not found")
+		return
+	} else if err != nil {
+		responses.Error(c, responses.InternalError, "Error fetching upload")
+		return
+	}
+
+	objectName, contentType, err := verifyUpload(ctx, request.Key, info)
+	if errors.Is(err, helpers.ErrInvalidImage) {
+		helpers.Blobs.Remove(ctx, request.Key)
+		responses.Error(c, responses.InvalidImage, "Upload is not a valid image")
+		return
+	} else if err != nil {
+		responses.Error(c, responses.InternalError, "Error verifying upload")
+		return
+	}
+
+	// only the verified version is copied, the upload may have been replaced since
+	err = helpers.Blobs.Copy(ctx, request.Key, objectName, info.ETag)
+	if errors.Is(err, helpers.ErrBlobChanged) {
+		helpers.Blobs.Remove(ctx, request.Key)
+		responses.Error(c, responses.Conflict, "Upload changed during verification")
+		return
+	} else if err != nil {
+		responses.Error(c, responses.InternalError, "Error storing upload")
+		return
+	}
+	// the presigned policy stays valid for a while, so the staged object is
+	// dropped to stop it from being replaced after verification
+	helpers.Blobs.Remove(ctx, request.Key)
+
+	image := &multipart.FileHeader{
+		Filename: objectName,
+		Size:     info.Size,
+		Header:   textproto.MIMEHeader{"Content-Type": {contentType}},
+	}
+	update := bson.M{"$set": bson.M{"image": image, "updatedat": time.Now()}, "$inc": bson.M{"version": 1}}
+	before, after, err := findAndUpdate(ctx, collection, bson.M{"_id": request.ID, "deleted_at": bson.M{"$exists": false}}, update, nil)
+	// the target was moved to the trash in the meantime
+	if err == mongo.ErrNoDocuments {
+		removeImageIfOrphaned(ctx, objectName)
+		responses.Error(c, responses.NotFound, "Target not found")
+		return
+	} else if err != nil {
+		responses.Error(c, responses.InternalError, "Error updating target")
+		return
+	}
+	recordAudit(c, models.AuditUpdate, request.Target, request.ID, before, after)
+	if request.Target == entityProduct {
+		recordProductRevision(c.Request.Context(), currentUserID(c), after, 0)
+	}
+
+	if oldImage != objectName {
+		removeImageIfOrphaned(ctx, oldImage)
+	}
+
+	responses.Success(c, http.StatusOK, "Upload attached", gin.H{
+		"id":     request.ID,
+		"target": request.Target,
+		"image":  objectName,
+	})
+}
+
+// verifyUpload checks size and content of the staged object and
+// returns its content addressed name
+func verifyUpload(ctx context.Context, key string, info helpers.BlobInfo) (string, string, error) {
+	if info.Size > helpers.MaxUploadSize() {
+		return "", "", helpers.ErrInvalidImage
+	}
+
+	object, err := helpers.Blobs.Get(ctx, key, info.ETag)
+	if err != nil {
+		return "", "", err
+	}
+	defer object.Close()
+
+	hash := sha256.New()
+	contentType, err := helpers.DetectImageType(io.TeeReader(object, hash))
+	if err != nil {
+		return "", "", err
+	}
+	if _, err := io.Copy(hash, object); err != nil {
+		return "", "", err
+	}
+
+	return helpers.CatalogPrefix + hex.EncodeToString(hash.Sum(nil)) + helpers.ImageExtensions[contentType], contentType, nil
+}
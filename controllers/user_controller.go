@@ -3,18 +3,20 @@ package controllers
 
 import (
 	"context"
-	"fmt"
+	"gin-api/responses"
 	"log"
+	"math"
 	"net/http"
+	"strconv"
+	"sync"
 	"time"
 
-	"github.com/dgrijalva/jwt-go"
 	"github.com/gin-gonic/gin"
-	"github.com/go-playground/validator/v10"
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
-	"golang.org/x/crypto/bcrypt"
+	"go.mongodb.org/mongo-driver/mongo/options"
 
 	"gin-api/configs"
 	"gin-api/helpers"
@@ -27,59 +29,141 @@ type loginResponse struct {
 }
 
 var userCollection *mongo.Collection = configs.GetCollection(configs.DB, "users")
-var validate = validator.New()
 
-func HashPassword(password string) string {
-	bytes, err := bcrypt.GenerateFromPassword([]byte(password), 14)
+func HashPassword(password string) (string, error) {
+	return helpers.Passwords.Hash(password)
+}
+
+var dummyHash struct {
+	once sync.Once
+	hash string
+}
+
+// dummyPasswordHash is compared when the username does not exist
+func dummyPasswordHash() string {
+	dummyHash.once.Do(func() {
+		hash, err := HashPassword(uuid.New().String())
+		if err != nil {
+			log.Println(err)
+		}
+		dummyHash.hash = hash
+	})
+	return dummyHash.hash
+}
+
+func VerifyPassword(userPassword string, providedPassword string) bool {
+	valid, err := helpers.Passwords.Verify(userPassword, providedPassword)
+	if err != nil {
+		log.Println(err)
+	}
+	return valid
+}
+
+// rehashPassword stores the password hashed with the current settings, unless
+// the stored hash changed in between
+func rehashPassword(ctx context.Context, user models.User, password string) error {
+	if !helpers.Passwords.NeedsRehash(user.Password) {
+		return nil
+	}
+
+	hash, err := HashPassword(password)
 	if err != nil {
-		log.Panic(err)
+		return err
 	}
 
-	return string(bytes)
+	filter := bson.M{"_id": user.ID, "password": user.Password}
+	_, err = userCollection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"password": hash}})
+	return err
 }
 
-func VerifyPassword(userPassword string, providedPassword string) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(providedPassword), []byte(userPassword))
-	return err == nil
+// rolePermissions returns the permissions granted to the tokens of a role
+func rolePermissions(roleName string) []string {
+	if roleName == "admin" {
+		return models.ApiKeyScopes
+	}
+	return nil
+}
+
+// loginToken starts a session for the request and signs the token returned on login
+func loginToken(c *gin.Context, user models.User, roleName string) (string, error) {
+	expiresAt := time.Now().Add(loginTokenExpiration)
+	sessionID, err := createSession(c, user.ID, expiresAt)
+	if err != nil {
+		return "", err
+	}
+
+	claims := helpers.SignedDetails{
+		UserID:       user.ID,
+		RoleType:     roleName,
+		SessionID:    sessionID,
+		Permissions:  rolePermissions(roleName),
+		TokenVersion: user.Token_version,
+	}
+	claims.ExpiresAt = jwt.NewNumericDate(expiresAt)
+
+	return helpers.GenerateAllTokens(&claims)
 }
 
 // CreateUser creates a new user
 func CreateUser(c *gin.Context) {
-	var user models.User
-	if err := c.ShouldBind(&user); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON format"})
+	var request models.CreateUserRequest
+	if err := c.ShouldBind(&request); err != nil {
+		responses.Validation(c, err)
 		return
 	}
 
-	password := HashPassword(user.Password)
+	if err := helpers.ValidatePassword(request.Password, request.Username, request.Email); err != nil {
+		responses.Error(c, responses.PasswordPolicy, err.Error())
+		return
+	}
+
+	password, err := HashPassword(request.Password)
+	if err != nil {
+		responses.Error(c, responses.InternalError, "Error creating user")
+		return
+	}
 
-	user.ID = uuid.New().String()
-	user.Password = password
+	user := models.User{
+		ID:       uuid.New().String(),
+		Username: request.Username,
+		Password: password,
+		Name:     request.Name,
+		Email:    request.Email,
+		Image:    request.Image,
+		Role_id:  request.Role_id,
+		Version:  1,
+	}
 	user.Created_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 	user.Updated_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 
-	_, err := userCollection.InsertOne(context.Background(), user)
+	// Upload the optional avatar
+	if user.Image != nil {
+		avatar, err := helpers.UploadAvatar(user.ID, user.Image)
+		if err != nil {
+			avatarError(c, err)
+			return
+		}
+		user.Image = avatar
+	}
+
+	_, err = userCollection.InsertOne(context.Background(), user)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error creating user"})
+		responses.Error(c, responses.InternalError, "Error creating user")
 		return
 	}
+	recordAudit(c, models.AuditCreate, entityUser, user.ID, nil, user)
 
 	result := gin.H{
 		"id":         user.ID,
 		"username":   user.Username,
-		"password":   user.Password,
 		"name":       user.Name,
-		"image":      user.Image.Filename,
+		"avatar_url": helpers.AvatarURL(user.Image),
 		"roles":      user.Role_id,
 		"created_at": user.Created_at,
 		"update_at":  user.Updated_at,
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"message": "User created",
-		"status":  http.StatusOK,
-		"data":    result,
-	})
+	responses.Success(c, http.StatusOK, "User created", result)
 }
 
 // Login is the api used to tget a single user
@@ -91,46 +175,112 @@ func Login(c *gin.Context) {
 
 	request := new(LoginRequest)
 	if err := c.ShouldBind(request); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"status":  http.StatusBadRequest,
-			"message": err.Error(),
-		})
+		responses.Validation(c, err)
 		return
 	}
 
-	var user models.User
+	ctx := context.Background()
+	accountKey := accountAttemptKey(request.Username)
+	ipKey := ipAttemptKey(c.ClientIP())
 
-	err := userCollection.FindOne(context.Background(), bson.M{"username": request.Username}).Decode(&user)
+	blockedUntil, err := loginBlockedUntil(ctx, accountKey, ipKey)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "login or password is incorrect"})
+		responses.Error(c, responses.InternalError, "Error signing in")
+		return
+	}
+	if wait := time.Until(blockedUntil); wait > 0 {
+		recordLoginEvent(c, request.Username, "", "blocked")
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		responses.Error(c, responses.AccountLocked, "Too many failed attempts, try again later")
+		return
+	}
+
+	var user models.User
+
+	err = userCollection.FindOne(ctx, bson.M{"username": request.Username}).Decode(&user)
+	if err != nil && err != mongo.ErrNoDocuments {
+		responses.Error(c, responses.InternalError, "Error signing in")
+		return
+	}
+	userFound := err == nil
+
+	// unknown users are checked against a dummy hash so both cases take as long
+	passwordHash := user.Password
+	if !userFound {
+		passwordHash = dummyPasswordHash()
+	}
+
+	passwordIsValid := VerifyPassword(request.Password, passwordHash)
+	if !userFound || !passwordIsValid {
+		reason := "wrong_password"
+		if !userFound {
+			reason = "unknown_user"
+		}
+		loginFailed(c, request.Username, user.ID, reason)
+
+		responses.Error(c, responses.InvalidCredentials, "login or password is incorrect")
 		return
 	}
 
+	if err := clearLoginFailures(ctx, accountKey); err != nil {
+		log.Println(err)
+	}
+	if err := rehashPassword(ctx, user, request.Password); err != nil {
+		log.Println(err)
+	}
+
+	completeLogin(ctx, c, user)
+}
+
+// completeLogin answers an authenticated login with the token, or the
+// two-factor step the user still has to go through
+func completeLogin(ctx context.Context, c *gin.Context, user models.User) {
 	var role models.Role
-	err = roleCollection.FindOne(context.Background(), bson.M{"_id": user.Role_id}).Decode(&role)
+	err := roleCollection.FindOne(ctx, bson.M{"_id": user.Role_id}).Decode(&role)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Role not found"})
+		responses.Error(c, responses.InternalError, "Role not found")
+		return
+	}
+
+	if user.PendingVerification {
+		responses.Error(c, responses.EmailNotVerified, "Email not verified")
 		return
 	}
 
-	passwordIsValid := VerifyPassword(request.Password, user.Password)
-	if !passwordIsValid {
-		c.JSON(http.StatusUnauthorized, gin.H{
-			"status":  http.StatusUnauthorized,
-			"message": "Invalid password",
+	// two-factor users get a challenge to exchange for the token at /api/auth/mfa/verify
+	if user.Mfa_enabled {
+		challenge, err := scopedToken(user, helpers.ScopeMfaChallenge, mfaChallengeExpiration)
+		if err != nil {
+			log.Println(err)
+			responses.Error(c, responses.InternalError, "Error signing in")
+			return
+		}
+
+		responses.Success(c, http.StatusOK, "Two-factor authentication required", gin.H{
+			"mfa_required":    true,
+			"challenge_token": challenge,
 		})
 		return
 	}
 
-	claims := jwt.MapClaims{}
-	claims["id"] = user.ID
-	claims["roleType"] = role.Name
-	claims["exp"] = time.Now().Add(time.Hour * 3).Unix() // expired in 3 hours
+	if role.Require_mfa {
+		enrollToken, err := scopedToken(user, helpers.ScopeMfaEnroll, mfaEnrollExpiration)
+		if err != nil {
+			log.Println(err)
+			responses.Error(c, responses.InternalError, "Error signing in")
+			return
+		}
+
+		responses.ErrorWithData(c, responses.MfaEnrollmentRequired, "Two-factor authentication must be set up for your role", gin.H{
+			"enrollment_token": enrollToken,
+		})
+		return
+	}
 
-	token, errGenerateToken := helpers.GenerateAllTokens(&claims)
+	token, errGenerateToken := loginToken(c, user, role.Name)
 	if errGenerateToken != nil {
 		log.Println(errGenerateToken)
-		c.Status(http.StatusUnauthorized)
+		responses.Error(c, responses.InternalError, "Error signing in")
 		return
 	}
 
@@ -141,45 +291,42 @@ func Login(c *gin.Context) {
 		"token": token,
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"message": "Login Successfully!!!",
-		"status":  http.StatusOK,
-		"data":    result,
-	})
+	responses.Success(c, http.StatusOK, "Login Successfully!!!", result)
 }
 
 // GetUsers returns all users
 func GetUsers(c *gin.Context) {
 	cur, err := userCollection.Find(context.Background(), bson.D{})
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching users"})
+		responses.Error(c, responses.InternalError, "Error fetching users")
 		return
 	}
 	defer cur.Close(context.Background())
 
 	var users []models.User
 	if err := cur.All(context.Background(), &users); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error decoding users"})
+		responses.Error(c, responses.InternalError, "Error decoding users")
 		return
 	}
 
+	for i := range users {
+		users[i].AvatarURL = viewerAvatarURL(c, users[i])
+	}
+
 	if len(users) == 0 {
-		c.JSON(http.StatusOK, gin.H{
-			"message": "No Data Users",
-			"data":    []models.User{}, // Empty slice of models.User
-		})
+		responses.Success(c, http.StatusOK, "No Data Users", []models.User{})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"message": "Get Users",
-		"data":    users,
-	})
+	responses.Success(c, http.StatusOK, "Get Users", users)
 }
 
 // GetUserByID returns a user by ID
 func GetUserByID(c *gin.Context) {
-	userID := c.Param("id")
+	userID, ok := bindID(c)
+	if !ok {
+		return
+	}
 
 	filter := bson.M{"_id": userID}
 	var user models.User
@@ -188,68 +335,233 @@ func GetUserByID(c *gin.Context) {
 	if err != nil {
 		// Check if the user is not found
 		if err == mongo.ErrNoDocuments {
-			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			responses.Error(c, responses.UserNotFound, "User not found")
 			return
 		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching user"})
+		responses.Error(c, responses.InternalError, "Error fetching user")
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"message": "Get Users By id",
-		"data":    user,
-	})
+	user.AvatarURL = viewerAvatarURL(c, user)
+
+	setETag(c, user.Version)
+	responses.Success(c, http.StatusOK, "Get Users By id", user)
 }
 
-// UpdateUser updates a user by ID
-func UpdateUser(c *gin.Context) {
-	userID := c.Param("id")
+// usernameOrEmailTaken tells whether a user other than userID has the username or email,
+// empty values are not checked
+func usernameOrEmailTaken(ctx context.Context, userID, username, email string) (bool, error) {
+	var taken []bson.M
+	if username != "" {
+		taken = append(taken, bson.M{"username": username})
+	}
+	if email != "" {
+		taken = append(taken, bson.M{"email": email})
+	}
+	if len(taken) == 0 {
+		return false, nil
+	}
+
+	count, err := userCollection.CountDocuments(ctx, bson.M{"_id": bson.M{"$ne": userID}, "$or": taken})
+	return count > 0, err
+}
 
-	var updateUser models.User
-	if err := c.ShouldBindJSON(&updateUser); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+// UpdateUser replaces the profile of a user by ID. The role is not part of the
+// profile, it only changes through PatchUser, which refuses the tokens of the old role.
+func UpdateUser(c *gin.Context) {
+	userID, ok := bindID(c)
+	if !ok {
 		return
 	}
 
 	filter := bson.M{"_id": userID}
-	update := bson.M{"$set": updateUser}
-	result, err := userCollection.UpdateOne(context.TODO(), filter, update)
+	if !ifMatch(c, filter) {
+		return
+	}
+
+	var request models.UserUpdate
+	if err := c.ShouldBindJSON(&request); err != nil {
+		responses.Validation(c, err)
+		return
+	}
+
+	ctx := c.Request.Context()
+	taken, err := usernameOrEmailTaken(ctx, userID, request.Username, request.Email)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error updating user"})
+		responses.Error(c, responses.InternalError, "Error updating user")
 		return
 	}
+	if taken {
+		responses.Error(c, responses.UserExists, "Username or email already registered")
+		return
+	}
+
+	set := toDocument(request)
+	set["updated_at"] = time.Now()
+	update := bson.M{"$set": set, "$inc": bson.M{"version": 1}}
+	if request.Email == "" {
+		update["$unset"] = bson.M{"email": ""}
+	}
 
-	if result.ModifiedCount == 0 {
-		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+	var user models.User
+	before, after, err := findAndUpdate(ctx, userCollection, filter, update, &user)
+	if err == mongo.ErrNoDocuments {
+		missedWrite(c, userCollection, filter, responses.UserNotFound, "User not found")
+		return
+	} else if err != nil {
+		responses.Error(c, responses.InternalError, "Error updating user")
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{
-		"message": "User updated",
-		"data":    updateUser,
-	})
+	recordAudit(c, models.AuditUpdate, entityUser, userID, before, after)
+
+	user.AvatarURL = viewerAvatarURL(c, user)
+
+	setETag(c, user.Version)
+	responses.Success(c, http.StatusOK, "User updated", user)
+}
+
+// PatchUser changes only the fields sent as JSON Merge Patch or form fields
+func PatchUser(c *gin.Context) {
+	userID, ok := bindID(c)
+	if !ok {
+		return
+	}
+
+	filter := bson.M{"_id": userID}
+	if !ifMatch(c, filter) {
+		return
+	}
+
+	var patch models.UserPatch
+	set, unset, ok := bindMergePatch(c, &patch, "name")
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	if patch.Username != nil || patch.Email != nil {
+		var username, email string
+		if patch.Username != nil {
+			username = *patch.Username
+		}
+		if patch.Email != nil {
+			email = *patch.Email
+		}
+		taken, err := usernameOrEmailTaken(ctx, userID, username, email)
+		if err != nil {
+			responses.Error(c, responses.InternalError, "Error updating user")
+			return
+		}
+		if taken {
+			responses.Error(c, responses.UserExists, "Username or email already registered")
+			return
+		}
+	}
+
+	set["updated_at"] = time.Now()
+	update := bson.M{"$set": set, "$inc": bson.M{"version": 1}}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+
+	// target is filter, narrowed to the role read below when the role changes
+	target := filter
+	roleChanged := false
+	if patch.Role_id != nil {
+		count, err := roleCollection.CountDocuments(ctx, bson.M{"_id": *patch.Role_id})
+		if err != nil {
+			responses.Error(c, responses.InternalError, "Error updating user")
+			return
+		}
+		if count == 0 {
+			responses.Error(c, responses.RoleNotFound, "Role not found")
+			return
+		}
+
+		var current models.User
+		err = userCollection.FindOne(ctx, filter, options.FindOne().SetProjection(bson.M{"role_id": 1})).Decode(&current)
+		if err == mongo.ErrNoDocuments {
+			missedWrite(c, userCollection, filter, responses.UserNotFound, "User not found")
+			return
+		} else if err != nil {
+			responses.Error(c, responses.InternalError, "Error updating user")
+			return
+		}
+		// tokens carry the role, like after a password change they must be refused.
+		// The update only applies to the role read here.
+		if roleChanged = current.Role_id != *patch.Role_id; roleChanged {
+			target = bson.M{"role_id": current.Role_id}
+			if current.Role_id == "" {
+				target["role_id"] = bson.M{"$in": bson.A{"", nil}}
+			}
+			for key, value := range filter {
+				target[key] = value
+			}
+			update["$inc"] = bson.M{"version": 1, "token_version": 1}
+		}
+	}
+
+	var user models.User
+	before, after, err := findAndUpdate(ctx, userCollection, target, update, &user)
+	if err == mongo.ErrNoDocuments {
+		missedWrite(c, userCollection, filter, responses.UserNotFound, "User not found")
+		return
+	} else if err != nil {
+		responses.Error(c, responses.InternalError, "Error updating user")
+		return
+	}
+	recordAudit(c, models.AuditUpdate, entityUser, userID, before, after)
+
+	// the tokens are already refused because of token_version, this keeps the list accurate
+	if roleChanged {
+		if err := revokeSessions(ctx, bson.M{"user_id": userID}); err != nil {
+			log.Printf("revoking sessions of user %s: %v", userID, err)
+		}
+	}
+
+	user.AvatarURL = viewerAvatarURL(c, user)
+
+	setETag(c, user.Version)
+	responses.Success(c, http.StatusOK, "User updated", user)
 }
 
 // DeleteUser deletes a user by ID
 func DeleteUser(c *gin.Context) {
-	userID := c.Param("id")
+	userID, ok := bindID(c)
+	if !ok {
+		return
+	}
 
 	filter := bson.M{"_id": userID}
-	result, err := userCollection.DeleteOne(context.Background(), filter)
+	if !ifMatch(c, filter) {
+		return
+	}
+
+	var before bson.M
+	err := userCollection.FindOneAndDelete(context.Background(), filter).Decode(&before)
 	if err == mongo.ErrNoDocuments {
-		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		missedWrite(c, userCollection, filter, responses.UserNotFound, "User not found")
 		return
 	} else if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error deleting user"})
+		responses.Error(c, responses.InternalError, "Error deleting user")
 		return
 	}
+	recordAudit(c, models.AuditDelete, entityUser, userID, before, nil)
 
-	c.JSON(http.StatusOK, gin.H{
-		"message": fmt.Sprintf("User deleted. %d documents deleted", result.DeletedCount),
-	})
+	// the avatar goes with the user, like the images of purged products
+	if image, ok := before["image"].(bson.M); ok {
+		filename, _ := image["filename"].(string)
+		removeImageIfOrphaned(context.Background(), filename)
+	}
+
+	responses.Success(c, http.StatusOK, "User deleted", nil)
 }
 
 func OneUsersHandler(c *gin.Context) {
-	id := c.Param("id")
+	id, ok := bindID(c)
+	if !ok {
+		return
+	}
 
 	query := []bson.M{
 		{
@@ -268,31 +580,27 @@ func OneUsersHandler(c *gin.Context) {
 		},
 		{
 			"$project": bson.M{
-				"_id":           0,
-				"user_id":       "$_id",
-				"user_name":     "$name",
-				"user_password": "$password",
-				"role_id":       "$role_id",
-				"role_name":     "$user_roles.name",
+				"_id":       0,
+				"user_id":   "$_id",
+				"user_name": "$name",
+				"role_id":   "$role_id",
+				"role_name": "$user_roles.name",
 			},
 		},
 	}
 
 	cur, err := userCollection.Aggregate(context.TODO(), query)
 	if err != nil {
-		c.JSON(500, gin.H{"error": "Internal Server Error"})
+		responses.Error(c, responses.InternalError, "Internal Server Error")
 		return
 	}
 	defer cur.Close(context.TODO())
 
 	var result []bson.M
 	if err := cur.All(context.TODO(), &result); err != nil {
-		c.JSON(500, gin.H{"error": "Internal Server Error"})
+		responses.Error(c, responses.InternalError, "Internal Server Error")
 		return
 	}
 
-	c.JSON(200, gin.H{
-		"message": "Find One Users",
-		"data":    result,
-	})
+	responses.Success(c, http.StatusOK, "Find One Users", result)
 }
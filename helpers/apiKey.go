@@ -0,0 +1,63 @@
+package helpers
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"net"
+	"strings"
+)
+
+// API keys look like gak_<prefix id>_<secret>
+const apiKeyTag = "gak_"
+
+// NewApiKey returns a key, its public prefix and the hash to store
+func NewApiKey() (string, string, string, error) {
+	id := make([]byte, 4)
+	secret := make([]byte, 32)
+	if _, err := rand.Read(id); err != nil {
+		return "", "", "", err
+	}
+	if _, err := rand.Read(secret); err != nil {
+		return "", "", "", err
+	}
+
+	prefix := apiKeyTag + hex.EncodeToString(id)
+	key := prefix + "_" + base64.RawURLEncoding.EncodeToString(secret)
+	return key, prefix, HashOpaqueToken(key), nil
+}
+
+// ApiKeyPrefix returns the public prefix of a key, or "" when it is not an API key
+func ApiKeyPrefix(key string) string {
+	if !strings.HasPrefix(key, apiKeyTag) {
+		return ""
+	}
+	i := strings.Index(key[len(apiKeyTag):], "_")
+	if i <= 0 {
+		return ""
+	}
+	return key[:len(apiKeyTag)+i]
+}
+
+// IPAllowed tells whether ip matches one of the addresses or CIDR ranges,
+// an empty allowlist allows every address
+func IPAllowed(ip string, allowlist []string) bool {
+	if len(allowlist) == 0 {
+		return true
+	}
+
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, entry := range allowlist {
+		if strings.Contains(entry, "/") {
+			if _, network, err := net.ParseCIDR(entry); err == nil && network.Contains(parsed) {
+				return true
+			}
+		} else if allowed := net.ParseIP(entry); allowed != nil && allowed.Equal(parsed) {
+			return true
+		}
+	}
+	return false
+}
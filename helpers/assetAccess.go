@@ -0,0 +1,204 @@
+package helpers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"gin-api/responses"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// object name prefixes, the prefix decides who may read an object
+const (
+	CatalogPrefix  = "catalog/"
+	AvatarPrefix   = "avatars/"
+	DocumentPrefix = "documents/"
+	UploadPrefix   = "uploads/"
+)
+
+type Visibility string
+
+const (
+	VisibilityPublic  Visibility = "public"
+	VisibilityPrivate Visibility = "private"
+)
+
+var ErrInvalidObjectName = errors.New("invalid object name")
+
+var ErrNoAssetSigningKey = errors.New("ASSET_SIGNING_KEY is not set")
+
+// uploads are staged objects and are never served
+var assetVisibility = map[string]Visibility{
+	CatalogPrefix:  VisibilityPublic,
+	AvatarPrefix:   VisibilityPrivate,
+	DocumentPrefix: VisibilityPrivate,
+}
+
+const signedURLExpiration = 5 * time.Minute
+
+// AssetVisibility returns the visibility of the object, or ErrInvalidObjectName
+// when the name is outside the allowed prefixes. Names without a prefix are
+// catalog images stored before prefixes were introduced.
+func AssetVisibility(objectName string) (Visibility, error) {
+	if objectName == "" || strings.Contains(objectName, "..") || strings.HasPrefix(objectName, "/") {
+		return "", ErrInvalidObjectName
+	}
+	if !strings.Contains(objectName, "/") {
+		return VisibilityPublic, nil
+	}
+
+	for prefix, visibility := range assetVisibility {
+		if strings.HasPrefix(objectName, prefix) {
+			return visibility, nil
+		}
+	}
+	return "", ErrInvalidObjectName
+}
+
+// CanAccessAsset tells whether the token claims grant access to a private object.
+// Admins read everything, users only their own avatars.
+func CanAccessAsset(claims *SignedDetails, objectName string) bool {
+	if claims == nil {
+		return false
+	}
+	if claims.RoleType == "admin" {
+		return true
+	}
+
+	return claims.UserID != "" && strings.HasPrefix(objectName, AvatarPrefix+claims.UserID+"/")
+}
+
+// assetSignature signs the object name and expiry with ASSET_SIGNING_KEY.
+// Without a key nothing can be signed or verified.
+func assetSignature(objectName string, expires int64) (string, error) {
+	key := os.Getenv("ASSET_SIGNING_KEY")
+	if key == "" {
+		return "", ErrNoAssetSigningKey
+	}
+
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(objectName + "\n" + strconv.FormatInt(expires, 10)))
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// AssetURL returns the absolute URL serving a public object
+func AssetURL(objectName string) string {
+	return AppURL() + "/api/product/image/" + objectName
+}
+
+// SignAssetURL returns an API URL serving the object until ttl has passed
+func SignAssetURL(objectName string, ttl time.Duration) (string, error) {
+	expires := time.Now().Add(ttl).Unix()
+	signature, err := assetSignature(objectName, expires)
+	if err != nil {
+		return "", err
+	}
+
+	query := url.Values{}
+	query.Set("expires", strconv.FormatInt(expires, 10))
+	query.Set("signature", signature)
+
+	return "/api/product/image/" + objectName + "?" + query.Encode(), nil
+}
+
+// VerifyAssetSignature checks the expires and signature query parameters of a signed URL
+func VerifyAssetSignature(objectName, expires, signature string) bool {
+	expiresAt, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || time.Now().Unix() > expiresAt {
+		return false
+	}
+
+	expected, err := assetSignature(objectName, expiresAt)
+	if err != nil {
+		return false
+	}
+	return hmac.Equal([]byte(signature), []byte(expected))
+}
+
+// bearerClaims returns the claims of the login token of the request, or nil
+// without one. The asset routes use middleware.OptionalAuthentication, which
+// checks the token like every other route, revoked sessions included.
+func bearerClaims(c *gin.Context) *SignedDetails {
+	value, ok := c.Get("userLogin")
+	if !ok {
+		return nil
+	}
+	claims, ok := value.(*SignedDetails)
+	// two-factor step tokens are not logins
+	if !ok || claims.Scope != "" {
+		return nil
+	}
+	return claims
+}
+
+// authorizeAsset checks the object name and, for private objects, the signature or
+// token of the request. It writes the error response and returns false when denied.
+func authorizeAsset(c *gin.Context, objectName string) (Visibility, bool) {
+	visibility, err := AssetVisibility(objectName)
+	if err != nil {
+		responses.Error(c, responses.InvalidObjectName, "Invalid object name")
+		return "", false
+	}
+	if visibility == VisibilityPublic {
+		return visibility, true
+	}
+
+	if signature := c.Query("signature"); signature != "" {
+		if VerifyAssetSignature(objectName, c.Query("expires"), signature) {
+			return visibility, true
+		}
+		responses.Error(c, responses.InvalidSignature, "Invalid or expired signature")
+		return "", false
+	}
+
+	claims := bearerClaims(c)
+	if claims == nil {
+		responses.Error(c, responses.Unauthorized, "Unauthorized")
+		return "", false
+	}
+	if !CanAccessAsset(claims, objectName) {
+		responses.Error(c, responses.Forbidden, "Forbidden")
+		return "", false
+	}
+	return visibility, true
+}
+
+// SignAsset returns a short lived signed URL for an object the caller may read
+func SignAsset(c *gin.Context) {
+	objectName := strings.TrimPrefix(c.Param("filename"), "/")
+
+	visibility, err := AssetVisibility(objectName)
+	if err != nil {
+		responses.Error(c, responses.InvalidObjectName, "Invalid object name")
+		return
+	}
+
+	claims := bearerClaims(c)
+	if claims == nil {
+		responses.Error(c, responses.Unauthorized, "Unauthorized")
+		return
+	}
+	if visibility == VisibilityPrivate && !CanAccessAsset(claims, objectName) {
+		responses.Error(c, responses.Forbidden, "Forbidden")
+		return
+	}
+
+	signedURL, err := SignAssetURL(objectName, signedURLExpiration)
+	if err != nil {
+		responses.Error(c, responses.InternalError, "Signed URLs are not configured")
+		return
+	}
+
+	responses.Success(c, http.StatusOK, "Signed URL created", gin.H{
+		"url":        signedURL,
+		"expires_at": time.Now().Add(signedURLExpiration),
+	})
+}
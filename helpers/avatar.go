@@ -0,0 +1,119 @@
+package helpers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"image"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/textproto"
+	"time"
+
+	"golang.org/x/image/draw"
+)
+
+// avatars are stored as square jpegs of this many pixels
+const AvatarSize = 256
+
+const avatarURLExpiration = time.Hour
+
+// larger images are refused before decoding, a small file may declare huge dimensions
+const maxAvatarPixels = 40_000_000
+
+var ErrFileTooLarge = errors.New("file too large")
+
+// CropSquareAvatar crops the image to its centered square and scales it to size x size
+func CropSquareAvatar(reader io.ReadSeeker, size int) ([]byte, error) {
+	config, _, err := image.DecodeConfig(reader)
+	if err != nil {
+		return nil, ErrInvalidImage
+	}
+	if config.Width <= 0 || config.Height <= 0 || int64(config.Width)*int64(config.Height) > maxAvatarPixels {
+		return nil, ErrFileTooLarge
+	}
+	if _, err := reader.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	src, _, err := image.Decode(reader)
+	if err != nil {
+		return nil, ErrInvalidImage
+	}
+
+	bounds := src.Bounds()
+	side := bounds.Dx()
+	if bounds.Dy() < side {
+		side = bounds.Dy()
+	}
+	x := bounds.Min.X + (bounds.Dx()-side)/2
+	y := bounds.Min.Y + (bounds.Dy()-side)/2
+	square := image.Rect(x, y, x+side, y+side)
+
+	dst := image.NewRGBA(image.Rect(0, 0, size, size))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, square, draw.Src, nil)
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, dst, &jpeg.Options{Quality: 90}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// UploadAvatar validates and crops the uploaded image and stores it below the
+// avatars of the user. It returns the header to save on the user document.
+func UploadAvatar(userID string, imageData *multipart.FileHeader) (*multipart.FileHeader, error) {
+	if imageData.Size > MaxUploadSize() {
+		return nil, ErrFileTooLarge
+	}
+
+	fileData, err := imageData.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer fileData.Close()
+
+	if _, err := DetectImageType(fileData); err != nil {
+		return nil, err
+	}
+	if _, err := fileData.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	avatar, err := CropSquareAvatar(fileData, AvatarSize)
+	if err != nil {
+		return nil, err
+	}
+
+	hash := sha256.Sum256(avatar)
+	objectName := AvatarPrefix + userID + "/" + hex.EncodeToString(hash[:]) + ".jpg"
+	err = Blobs.Put(context.Background(), objectName, bytes.NewReader(avatar), int64(len(avatar)), "image/jpeg")
+	if err != nil {
+		return nil, err
+	}
+
+	return &multipart.FileHeader{
+		Filename: objectName,
+		Size:     int64(len(avatar)),
+		Header:   textproto.MIMEHeader{"Content-Type": {"image/jpeg"}},
+	}, nil
+}
+
+// AvatarURL returns a signed URL for the avatar, or "" when the user has none
+// or URLs cannot be signed
+func AvatarURL(avatar *multipart.FileHeader) string {
+	if avatar == nil || avatar.Filename == "" {
+		return ""
+	}
+	signedURL, err := SignAssetURL(avatar.Filename, avatarURLExpiration)
+	if err != nil {
+		log.Printf("signing avatar URL: %v", err)
+		return ""
+	}
+	return signedURL
+}
@@ -0,0 +1,218 @@
+package helpers
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+const bucketName = "gin-api"
+
+var ErrBlobNotFound = errors.New("blob not found")
+
+// ErrBlobChanged is returned when a conditional operation finds another version of the object
+var ErrBlobChanged = errors.New("blob changed")
+
+// BlobInfo describes a stored object
+type BlobInfo struct {
+	Key          string    `json:"key"`
+	Size         int64     `json:"size"`
+	LastModified time.Time `json:"last_modified"`
+	ETag         string    `json:"etag,omitempty"`
+	ContentType  string    `json:"content_type,omitempty"`
+}
+
+// BlobStore is the object storage used for uploaded images
+type BlobStore interface {
+	Put(ctx context.Context, objectName string, reader io.Reader, size int64, contentType string) error
+	Get(ctx context.Context, objectName, matchETag string) (io.ReadSeekCloser, error)
+	Stat(ctx context.Context, objectName string) (BlobInfo, error)
+	Copy(ctx context.Context, srcName, dstName, matchETag string) error
+	Remove(ctx context.Context, objectName string) error
+	List(ctx context.Context, prefix string) ([]BlobInfo, error)
+	PresignedPost(ctx context.Context, objectName, contentType string, size int64, expires time.Duration) (*url.URL, map[string]string, error)
+}
+
+type minioBlobStore struct {
+	mu     sync.Mutex
+	client *minio.Client
+	bucket string
+}
+
+// Blobs is the blob store shared by the handlers
+var Blobs BlobStore = NewMinioBlobStore()
+
+func newMinioClient() (*minio.Client, error) {
+	// Implementasi koneksi ke MinIO
+	endpoint := os.Getenv("MINIO_ENDPOINT")
+	accessKey := os.Getenv("MINIO_ACCESS_KEY_ID")
+	secretKey := os.Getenv("MINIO_SECRET_ACCESS_KEY_ID")
+
+	return minio.New(endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(accessKey, secretKey, ""),
+		Secure: false, // Ganti menjadi true jika menggunakan koneksi aman (HTTPS)
+	})
+}
+
+// NewMinioBlobStore creates a BlobStore backed by the MinIO bucket
+func NewMinioBlobStore() BlobStore {
+	return &minioBlobStore{bucket: bucketName}
+}
+
+// the client is created on first use, after configs has loaded the .env file
+func (s *minioBlobStore) minio() (*minio.Client, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.client != nil {
+		return s.client, nil
+	}
+
+	client, err := newMinioClient()
+	if err != nil {
+		return nil, err
+	}
+	s.client = client
+	return client, nil
+}
+
+func (s *minioBlobStore) Put(ctx context.Context, objectName string, reader io.Reader, size int64, contentType string) error {
+	client, err := s.minio()
+	if err != nil {
+		return err
+	}
+
+	_, err = client.PutObject(ctx, s.bucket, objectName, reader, size, minio.PutObjectOptions{ContentType: contentType})
+	return err
+}
+
+// Get opens the object, only while its ETag is matchETag unless that is empty
+func (s *minioBlobStore) Get(ctx context.Context, objectName, matchETag string) (io.ReadSeekCloser, error) {
+	client, err := s.minio()
+	if err != nil {
+		return nil, err
+	}
+
+	opts := minio.GetObjectOptions{}
+	if matchETag != "" {
+		if err := opts.SetMatchETag(matchETag); err != nil {
+			return nil, err
+		}
+	}
+	object, err := client.GetObject(ctx, s.bucket, objectName, opts)
+	if err != nil {
+		return nil, blobError(err)
+	}
+	return object, nil
+}
+
+func (s *minioBlobStore) Stat(ctx context.Context, objectName string) (BlobInfo, error) {
+	client, err := s.minio()
+	if err != nil {
+		return BlobInfo{}, err
+	}
+
+	object, err := client.StatObject(ctx, s.bucket, objectName, minio.StatObjectOptions{})
+	if err != nil {
+		return BlobInfo{}, blobError(err)
+	}
+
+	return BlobInfo{
+		Key:          object.Key,
+		Size:         object.Size,
+		LastModified: object.LastModified,
+		ETag:         object.ETag,
+		ContentType:  object.ContentType,
+	}, nil
+}
+
+// Copy copies the source object, only while its ETag is matchETag unless that is empty
+func (s *minioBlobStore) Copy(ctx context.Context, srcName, dstName, matchETag string) error {
+	client, err := s.minio()
+	if err != nil {
+		return err
+	}
+
+	_, err = client.CopyObject(ctx,
+		minio.CopyDestOptions{Bucket: s.bucket, Object: dstName},
+		minio.CopySrcOptions{Bucket: s.bucket, Object: srcName, MatchETag: matchETag},
+	)
+	return blobError(err)
+}
+
+func (s *minioBlobStore) Remove(ctx context.Context, objectName string) error {
+	client, err := s.minio()
+	if err != nil {
+		return err
+	}
+
+	return client.RemoveObject(ctx, s.bucket, objectName, minio.RemoveObjectOptions{})
+}
+
+func (s *minioBlobStore) List(ctx context.Context, prefix string) ([]BlobInfo, error) {
+	client, err := s.minio()
+	if err != nil {
+		return nil, err
+	}
+
+	var blobs []BlobInfo
+	for object := range client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{Prefix: prefix, Recursive: true}) {
+		if object.Err != nil {
+			return nil, object.Err
+		}
+		blobs = append(blobs, BlobInfo{
+			Key:          object.Key,
+			Size:         object.Size,
+			LastModified: object.LastModified,
+		})
+	}
+
+	return blobs, nil
+}
+
+// PresignedPost creates a POST policy which only accepts the given object name,
+// content type and exactly size bytes
+func (s *minioBlobStore) PresignedPost(ctx context.Context, objectName, contentType string, size int64, expires time.Duration) (*url.URL, map[string]string, error) {
+	client, err := s.minio()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	policy := minio.NewPostPolicy()
+	if err := policy.SetBucket(s.bucket); err != nil {
+		return nil, nil, err
+	}
+	if err := policy.SetKey(objectName); err != nil {
+		return nil, nil, err
+	}
+	if err := policy.SetExpires(time.Now().UTC().Add(expires)); err != nil {
+		return nil, nil, err
+	}
+	if err := policy.SetContentType(contentType); err != nil {
+		return nil, nil, err
+	}
+	if err := policy.SetContentLengthRange(size, size); err != nil {
+		return nil, nil, err
+	}
+
+	return client.PresignedPostPolicy(ctx, policy)
+}
+
+// blobError maps the MinIO "no such key" and "precondition failed" responses
+// to ErrBlobNotFound and ErrBlobChanged
+func blobError(err error) error {
+	switch minio.ToErrorResponse(err).Code {
+	case "NoSuchKey":
+		return ErrBlobNotFound
+	case "PreconditionFailed":
+		return ErrBlobChanged
+	}
+	return err
+}
@@ -0,0 +1,101 @@
+package helpers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Mailer sends the emails of the account flows
+type Mailer interface {
+	Send(ctx context.Context, to, subject, body string) error
+}
+
+// LogMailer prints the emails to the log, for local use
+type LogMailer struct{}
+
+func (LogMailer) Send(ctx context.Context, to, subject, body string) error {
+	log.Printf("mail to %s: %s\n%s", to, subject, body)
+	return nil
+}
+
+// FileMailer writes every email as a file in Dir, for local use
+type FileMailer struct {
+	Dir string
+}
+
+func (m FileMailer) Send(ctx context.Context, to, subject, body string) error {
+	if err := os.MkdirAll(m.Dir, 0o755); err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%d-%s.eml", time.Now().UnixNano(), strings.NewReplacer("@", "_at_", "/", "_").Replace(to))
+	return os.WriteFile(filepath.Join(m.Dir, name), []byte(message(mailFrom(), to, subject, body)), 0o644)
+}
+
+// SMTPMailer sends the emails through an SMTP server
+type SMTPMailer struct {
+	Addr     string
+	Username string
+	Password string
+	From     string
+}
+
+func (m SMTPMailer) Send(ctx context.Context, to, subject, body string) error {
+	var auth smtp.Auth
+	if m.Username != "" {
+		host := strings.Split(m.Addr, ":")[0]
+		auth = smtp.PlainAuth("", m.Username, m.Password, host)
+	}
+
+	return smtp.SendMail(m.Addr, auth, m.From, []string{to}, []byte(message(m.From, to, subject, body)))
+}
+
+func message(from, to, subject, body string) string {
+	return "From: " + from + "\r\n" +
+		"To: " + to + "\r\n" +
+		"Subject: " + subject + "\r\n" +
+		"Content-Type: text/plain; charset=UTF-8\r\n" +
+		"\r\n" + body + "\r\n"
+}
+
+func mailFrom() string {
+	if from := os.Getenv("MAIL_FROM"); from != "" {
+		return from
+	}
+	return "no-reply@gin-api.local"
+}
+
+// NewMailer picks the mailer from MAILER: smtp, file or log (default)
+func NewMailer() Mailer {
+	switch os.Getenv("MAILER") {
+	case "smtp":
+		return SMTPMailer{
+			Addr:     os.Getenv("SMTP_ADDR"),
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     mailFrom(),
+		}
+	case "file":
+		dir := os.Getenv("MAIL_DIR")
+		if dir == "" {
+			dir = "tmp/mail"
+		}
+		return FileMailer{Dir: dir}
+	default:
+		return LogMailer{}
+	}
+}
+
+// AppURL is the public base URL used in emailed links, set by APP_URL
+func AppURL() string {
+	if url := os.Getenv("APP_URL"); url != "" {
+		return strings.TrimSuffix(url, "/")
+	}
+	return "http://localhost:" + strings.TrimSpace(os.Getenv("PORT"))
+}
@@ -0,0 +1,224 @@
+package helpers
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// the mock provider signs anyone in, it is only mounted when OIDC_MOCK=true
+const (
+	MockOIDCProviderName = "mock"
+	MockOIDCPath         = "/mock-oidc"
+	MockOIDCClientID     = "mock-client"
+	MockOIDCClientSecret = "mock-secret"
+)
+
+const mockCodeExpiration = time.Minute
+
+// MockOIDCEnabled tells whether the mock provider is served and registered as the "mock" provider
+func MockOIDCEnabled() bool {
+	return os.Getenv("OIDC_MOCK") == "true"
+}
+
+// MockOIDCProvider is an offline OpenID Connect provider for development and tests.
+// Its authorization endpoint approves every request right away, the login_hint
+// parameter chooses the subject and email of the ID token.
+type MockOIDCProvider struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+
+	key   *rsa.PrivateKey
+	kid   string
+	mux   *http.ServeMux
+	mu    sync.Mutex
+	codes map[string]mockAuthorization
+}
+
+type mockAuthorization struct {
+	redirectURI string
+	challenge   string
+	nonce       string
+	subject     string
+	expiresAt   time.Time
+}
+
+// NewMockOIDCProvider returns a provider serving discovery, authorize, token and jwks below issuer
+func NewMockOIDCProvider(issuer, clientID, clientSecret string) (*MockOIDCProvider, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &MockOIDCProvider{
+		Issuer:       strings.TrimSuffix(issuer, "/"),
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		key:          key,
+		kid:          uuid.New().String(),
+		mux:          http.NewServeMux(),
+		codes:        map[string]mockAuthorization{},
+	}
+	p.mux.HandleFunc("/.well-known/openid-configuration", p.discovery)
+	p.mux.HandleFunc("/authorize", p.authorize)
+	p.mux.HandleFunc("/token", p.token)
+	p.mux.HandleFunc("/jwks", p.jwks)
+	return p, nil
+}
+
+func (p *MockOIDCProvider) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.mux.ServeHTTP(w, r)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func oauthError(w http.ResponseWriter, status int, code, description string) {
+	writeJSON(w, status, map[string]string{"error": code, "error_description": description})
+}
+
+func (p *MockOIDCProvider) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                p.Issuer,
+		"authorization_endpoint":                p.Issuer + "/authorize",
+		"token_endpoint":                        p.Issuer + "/token",
+		"jwks_uri":                              p.Issuer + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+func (p *MockOIDCProvider) authorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	redirectURI, err := url.Parse(query.Get("redirect_uri"))
+	if query.Get("client_id") != p.ClientID || err != nil || !redirectURI.IsAbs() {
+		oauthError(w, http.StatusBadRequest, "invalid_request", "unknown client or redirect_uri")
+		return
+	}
+
+	params := url.Values{}
+	params.Set("state", query.Get("state"))
+
+	if query.Get("response_type") != "code" || query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "" {
+		params.Set("error", "invalid_request")
+		params.Set("error_description", "the code flow with a S256 challenge is required")
+	} else {
+		subject := query.Get("login_hint")
+		if subject == "" {
+			subject = "mock-user"
+		}
+
+		code := uuid.New().String()
+		p.mu.Lock()
+		p.codes[code] = mockAuthorization{
+			redirectURI: redirectURI.String(),
+			challenge:   query.Get("code_challenge"),
+			nonce:       query.Get("nonce"),
+			subject:     subject,
+			expiresAt:   time.Now().Add(mockCodeExpiration),
+		}
+		p.mu.Unlock()
+		params.Set("code", code)
+	}
+
+	redirectURI.RawQuery = params.Encode()
+	http.Redirect(w, r, redirectURI.String(), http.StatusFound)
+}
+
+func (p *MockOIDCProvider) token(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		oauthError(w, http.StatusMethodNotAllowed, "invalid_request", "POST required")
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		oauthError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+
+	clientID, clientSecret, ok := r.BasicAuth()
+	if ok {
+		clientID, _ = url.QueryUnescape(clientID)
+		clientSecret, _ = url.QueryUnescape(clientSecret)
+	} else {
+		clientID, clientSecret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	if clientID != p.ClientID || subtle.ConstantTimeCompare([]byte(clientSecret), []byte(p.ClientSecret)) != 1 {
+		oauthError(w, http.StatusUnauthorized, "invalid_client", "client authentication failed")
+		return
+	}
+	if r.PostForm.Get("grant_type") != "authorization_code" {
+		oauthError(w, http.StatusBadRequest, "unsupported_grant_type", "")
+		return
+	}
+
+	// codes are single use
+	p.mu.Lock()
+	authorization, found := p.codes[r.PostForm.Get("code")]
+	delete(p.codes, r.PostForm.Get("code"))
+	p.mu.Unlock()
+
+	if !found || time.Now().After(authorization.expiresAt) || authorization.redirectURI != r.PostForm.Get("redirect_uri") {
+		oauthError(w, http.StatusBadRequest, "invalid_grant", "invalid or expired code")
+		return
+	}
+	if PKCEChallenge(r.PostForm.Get("code_verifier")) != authorization.challenge {
+		oauthError(w, http.StatusBadRequest, "invalid_grant", "code_verifier does not match the challenge")
+		return
+	}
+
+	email := authorization.subject
+	if !strings.Contains(email, "@") {
+		email += "@example.com"
+	}
+
+	now := time.Now()
+	claims := OIDCClaims{
+		Email:             email,
+		EmailVerified:     true,
+		Name:              authorization.subject,
+		PreferredUsername: strings.SplitN(email, "@", 2)[0],
+		Nonce:             authorization.nonce,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    p.Issuer,
+			Subject:   authorization.subject,
+			Audience:  jwt.ClaimStrings{p.ClientID},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(5 * time.Minute)),
+		},
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = p.kid
+	idToken, err := token.SignedString(p.key)
+	if err != nil {
+		oauthError(w, http.StatusInternalServerError, "server_error", err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": uuid.New().String(),
+		"token_type":   "Bearer",
+		"expires_in":   300,
+		"id_token":     idToken,
+	})
+}
+
+func (p *MockOIDCProvider) jwks(w http.ResponseWriter, r *http.Request) {
+	jwk, _ := NewJWK(p.kid, "RS256", &p.key.PublicKey)
+	writeJSON(w, http.StatusOK, map[string]interface{}{"keys": []JWK{jwk}})
+}
@@ -0,0 +1,151 @@
+package helpers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+// startMockOIDC serves a mock provider and returns a client provider configured for it
+func startMockOIDC(t *testing.T) *OIDCProvider {
+	t.Helper()
+
+	var mock *MockOIDCProvider
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mock.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	var err error
+	if mock, err = NewMockOIDCProvider(server.URL, MockOIDCClientID, MockOIDCClientSecret); err != nil {
+		t.Fatal(err)
+	}
+	return NewOIDCProvider(MockOIDCProviderName, server.URL, MockOIDCClientID, MockOIDCClientSecret)
+}
+
+// authorize follows the provider URL like a browser and returns the query of
+// the redirect back to the callback
+func authorize(t *testing.T, provider *OIDCProvider, state, nonce, challenge, loginHint string) url.Values {
+	t.Helper()
+
+	authURL, err := provider.AuthCodeURL(context.Background(), state, nonce, challenge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loginHint != "" {
+		authURL += "&login_hint=" + url.QueryEscape(loginHint)
+	}
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.Get(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("authorize: status %d", resp.StatusCode)
+	}
+
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if location.Path != "/api/auth/oidc/"+MockOIDCProviderName+"/callback" {
+		t.Fatalf("redirected to %s", location)
+	}
+	return location.Query()
+}
+
+func TestOIDCFlow(t *testing.T) {
+	provider := startMockOIDC(t)
+	ctx := context.Background()
+
+	verifier, challenge, err := NewPKCEVerifier()
+	if err != nil {
+		t.Fatal(err)
+	}
+	callback := authorize(t, provider, "state-1", "nonce-1", challenge, "alice@example.org")
+	if callback.Get("state") != "state-1" || callback.Get("code") == "" {
+		t.Fatalf("callback query %v", callback)
+	}
+
+	idToken, err := provider.Exchange(ctx, callback.Get("code"), verifier)
+	if err != nil {
+		t.Fatalf("Exchange: %v", err)
+	}
+	claims, err := provider.VerifyIDToken(ctx, idToken, "nonce-1")
+	if err != nil {
+		t.Fatalf("VerifyIDToken: %v", err)
+	}
+	if claims.Subject != "alice@example.org" || claims.Email != "alice@example.org" || !claims.EmailVerified {
+		t.Fatalf("claims %+v", claims)
+	}
+
+	// codes are single use
+	if _, err := provider.Exchange(ctx, callback.Get("code"), verifier); err == nil {
+		t.Fatal("a code was exchanged twice")
+	}
+}
+
+func TestOIDCFlowRequiresVerifier(t *testing.T) {
+	provider := startMockOIDC(t)
+
+	_, challenge, err := NewPKCEVerifier()
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherVerifier, _, err := NewPKCEVerifier()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	callback := authorize(t, provider, "state-1", "nonce-1", challenge, "")
+	if _, err := provider.Exchange(context.Background(), callback.Get("code"), otherVerifier); err == nil {
+		t.Fatal("a code was exchanged with another verifier")
+	}
+}
+
+func TestOIDCFlowChecksNonce(t *testing.T) {
+	provider := startMockOIDC(t)
+	ctx := context.Background()
+
+	verifier, challenge, err := NewPKCEVerifier()
+	if err != nil {
+		t.Fatal(err)
+	}
+	callback := authorize(t, provider, "state-1", "nonce-1", challenge, "")
+	idToken, err := provider.Exchange(ctx, callback.Get("code"), verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := provider.VerifyIDToken(ctx, idToken, "nonce-2"); !errors.Is(err, ErrInvalidIDToken) {
+		t.Fatalf("got %v, want ErrInvalidIDToken", err)
+	}
+}
+
+func TestOIDCRefusesForeignIDToken(t *testing.T) {
+	provider := startMockOIDC(t)
+	other := startMockOIDC(t)
+	ctx := context.Background()
+
+	// a valid token of another issuer
+	verifier, challenge, err := NewPKCEVerifier()
+	if err != nil {
+		t.Fatal(err)
+	}
+	callback := authorize(t, other, "state-1", "nonce-1", challenge, "")
+	idToken, err := other.Exchange(ctx, callback.Get("code"), verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := provider.VerifyIDToken(ctx, idToken, "nonce-1"); err == nil {
+		t.Fatal("accepted an id token of another provider")
+	}
+}
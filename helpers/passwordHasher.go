@@ -0,0 +1,171 @@
+package helpers
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var ErrUnknownPasswordHash = errors.New("unknown password hash format")
+
+// PasswordHasher hashes passwords with the configured algorithm and verifies
+// hashes made by any supported algorithm
+type PasswordHasher interface {
+	Hash(password string) (string, error)
+	Verify(password, hash string) (bool, error)
+	// NeedsRehash tells whether the hash was made with other settings than the current ones
+	NeedsRehash(hash string) bool
+}
+
+// Argon2Params are the argon2id settings, Memory is in KiB
+type Argon2Params struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+type passwordHasher struct {
+	algorithm  string
+	bcryptCost int
+	argon2     Argon2Params
+}
+
+// Passwords is the hasher used by every path that stores or checks a password
+var Passwords PasswordHasher = NewPasswordHasher()
+
+// NewPasswordHasher reads PASSWORD_HASHER (argon2id or bcrypt), BCRYPT_COST,
+// ARGON2_MEMORY, ARGON2_ITERATIONS and ARGON2_PARALLELISM
+func NewPasswordHasher() PasswordHasher {
+	algorithm := os.Getenv("PASSWORD_HASHER")
+	if algorithm != "bcrypt" {
+		algorithm = "argon2id"
+	}
+
+	return &passwordHasher{
+		algorithm:  algorithm,
+		bcryptCost: envInt("BCRYPT_COST", bcrypt.DefaultCost),
+		argon2: Argon2Params{
+			Memory:      uint32(envInt("ARGON2_MEMORY", 19*1024)),
+			Iterations:  uint32(envInt("ARGON2_ITERATIONS", 2)),
+			Parallelism: uint8(envInt("ARGON2_PARALLELISM", 1)),
+			SaltLength:  16,
+			KeyLength:   32,
+		},
+	}
+}
+
+func envInt(name string, fallback int) int {
+	value, err := strconv.Atoi(os.Getenv(name))
+	if err != nil || value <= 0 {
+		return fallback
+	}
+	return value
+}
+
+func isBcryptHash(hash string) bool {
+	return strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2y$")
+}
+
+func (h *passwordHasher) Hash(password string) (string, error) {
+	if h.algorithm == "bcrypt" {
+		bytes, err := bcrypt.GenerateFromPassword([]byte(password), h.bcryptCost)
+		if err != nil {
+			return "", err
+		}
+		return string(bytes), nil
+	}
+
+	salt := make([]byte, h.argon2.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, h.argon2.Iterations, h.argon2.Memory, h.argon2.Parallelism, h.argon2.KeyLength)
+
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, h.argon2.Memory, h.argon2.Iterations, h.argon2.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+func (h *passwordHasher) Verify(password, hash string) (bool, error) {
+	if isBcryptHash(hash) {
+		err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+		if err == bcrypt.ErrMismatchedHashAndPassword {
+			return false, nil
+		}
+		return err == nil, err
+	}
+
+	params, salt, key, err := decodeArgon2Hash(hash)
+	if err != nil {
+		return false, err
+	}
+	computed := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, uint32(len(key)))
+
+	return subtle.ConstantTimeCompare(key, computed) == 1, nil
+}
+
+func (h *passwordHasher) NeedsRehash(hash string) bool {
+	if isBcryptHash(hash) {
+		if h.algorithm != "bcrypt" {
+			return true
+		}
+		cost, err := bcrypt.Cost([]byte(hash))
+		return err != nil || cost != h.bcryptCost
+	}
+
+	if h.algorithm != "argon2id" {
+		return true
+	}
+	params, salt, key, err := decodeArgon2Hash(hash)
+	if err != nil {
+		return true
+	}
+	return params.Memory != h.argon2.Memory ||
+		params.Iterations != h.argon2.Iterations ||
+		params.Parallelism != h.argon2.Parallelism ||
+		uint32(len(salt)) != h.argon2.SaltLength ||
+		uint32(len(key)) != h.argon2.KeyLength
+}
+
+// decodeArgon2Hash parses $argon2id$v=19$m=...,t=...,p=...$salt$key
+func decodeArgon2Hash(hash string) (Argon2Params, []byte, []byte, error) {
+	var params Argon2Params
+
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return params, nil, nil, ErrUnknownPasswordHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, nil, nil, ErrUnknownPasswordHash
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism); err != nil {
+		return params, nil, nil, ErrUnknownPasswordHash
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, ErrUnknownPasswordHash
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return params, nil, nil, ErrUnknownPasswordHash
+	}
+
+	params.SaltLength = uint32(len(salt))
+	params.KeyLength = uint32(len(key))
+	return params, salt, key, nil
+}
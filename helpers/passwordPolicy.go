@@ -0,0 +1,90 @@
+package helpers
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const (
+	defaultPasswordMinLength = 8
+	passwordMaxLength        = 72
+)
+
+// passwords that pass the character rules but are still guessed first
+var commonPasswords = map[string]bool{
+	"password1":   true,
+	"password123": true,
+	"passw0rd":    true,
+	"p@ssw0rd":    true,
+	"qwerty123":   true,
+	"welcome1":    true,
+	"admin123":    true,
+	"letmein1":    true,
+	"iloveyou1":   true,
+	"abc12345":    true,
+}
+
+// PasswordPolicyError lists the rules a password breaks
+type PasswordPolicyError struct {
+	Reasons []string
+}
+
+func (e *PasswordPolicyError) Error() string {
+	return "password is too weak: " + strings.Join(e.Reasons, ", ")
+}
+
+// ValidatePassword checks the password against the strength policy. The
+// personal values, like username and email, must not be part of it.
+// The minimum length is set by PASSWORD_MIN_LENGTH.
+func ValidatePassword(password string, personal ...string) error {
+	var reasons []string
+
+	minLength := envInt("PASSWORD_MIN_LENGTH", defaultPasswordMinLength)
+	if len([]rune(password)) < minLength {
+		reasons = append(reasons, "must be at least "+strconv.Itoa(minLength)+" characters")
+	}
+	// bcrypt only uses the first 72 bytes
+	if len(password) > passwordMaxLength {
+		reasons = append(reasons, "must be at most "+strconv.Itoa(passwordMaxLength)+" bytes")
+	}
+
+	var lower, upper, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			symbol = true
+		}
+	}
+	classes := 0
+	for _, present := range []bool{lower, upper, digit, symbol} {
+		if present {
+			classes++
+		}
+	}
+	if classes < 3 {
+		reasons = append(reasons, "must use at least three of lowercase, uppercase, digits and symbols")
+	}
+
+	lowered := strings.ToLower(password)
+	if commonPasswords[lowered] {
+		reasons = append(reasons, "is too common")
+	}
+	for _, value := range personal {
+		if len(value) >= 3 && strings.Contains(lowered, strings.ToLower(value)) {
+			reasons = append(reasons, "must not contain your username or email")
+			break
+		}
+	}
+
+	if len(reasons) > 0 {
+		return &PasswordPolicyError{Reasons: reasons}
+	}
+	return nil
+}
@@ -0,0 +1,469 @@
+package helpers
+
+import (
+	"context"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"gin-api/configs"
+	"log"
+	"math/big"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var ErrUnknownSigningKey = errors.New("unknown signing key")
+
+var ErrNoKeyEncryptionKey = errors.New("JWT_KEY_ENCRYPTION_KEY must be 32 bytes encoded as base64")
+
+// signing keys are shared by every instance through this collection
+var signingKeyCollection *mongo.Collection = configs.GetCollection(configs.DB, "signing_keys")
+
+const (
+	defaultKeyRotation = 30 * 24 * time.Hour
+	// retired keys still verify for this long, it must exceed the token lifetime
+	defaultKeyOverlap = 24 * time.Hour
+	keyRefresh        = time.Minute
+	// unknown kids reload the keys at most this often, so forged kids cannot stall verification
+	keyForcedRefresh = 10 * time.Second
+	// the next key is stored this long before the current one stops signing,
+	// longer than the interval of StartKeyRotation
+	keyPlanAhead = 2 * time.Hour
+)
+
+// JWKSMaxAge is how long verifiers may cache the JWKS document
+const JWKSMaxAge = 5 * time.Minute
+
+// keyAnnounce is how long a new key is published before it signs, so verifiers
+// holding a cached JWKS, or an instance holding its keys, have seen it
+const keyAnnounce = JWKSMaxAge + keyRefresh
+
+// SigningKey is an asymmetric key used to sign tokens from Sign_from until
+// Sign_until and published for verification from its creation until
+// Verify_until. The private key is stored encrypted with JWT_KEY_ENCRYPTION_KEY.
+type SigningKey struct {
+	Kid          string    `bson:"_id"`
+	Alg          string    `bson:"alg"`
+	Private_key  string    `bson:"private_key"` // AES-GCM sealed PKCS8 PEM, base64
+	Created_at   time.Time `bson:"created_at"`
+	Sign_from    time.Time `bson:"sign_from"`
+	Sign_until   time.Time `bson:"sign_until"`
+	Verify_until time.Time `bson:"verify_until"`
+
+	private crypto.Signer
+}
+
+// JWK is a public key of the JWKS document
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// KeyRing holds the signing keys of the configured algorithm
+type KeyRing struct {
+	mu          sync.Mutex
+	algorithm   string
+	rotation    time.Duration
+	overlap     time.Duration
+	kek         []byte // encrypts the stored private keys
+	keys        []*SigningKey
+	refreshedAt time.Time
+	forcedAt    time.Time
+}
+
+// Keys is the key ring used for every token
+var Keys = NewKeyRing()
+
+// NewKeyRing reads JWT_ALGORITHM (RS256, EdDSA or HS256), JWT_KEY_ROTATION,
+// JWT_KEY_OVERLAP and JWT_KEY_ENCRYPTION_KEY. Without JWT_ALGORITHM tokens are
+// signed with RS256 when a key encryption key is set and with SECRET_KEY otherwise.
+func NewKeyRing() *KeyRing {
+	kek := keyEncryptionKey()
+	algorithm := os.Getenv("JWT_ALGORITHM")
+	if algorithm == "" && kek == nil {
+		algorithm = "HS256"
+	} else if algorithm != "EdDSA" && algorithm != "HS256" {
+		algorithm = "RS256"
+	}
+
+	return &KeyRing{
+		algorithm: algorithm,
+		rotation:  envDuration("JWT_KEY_ROTATION", defaultKeyRotation),
+		overlap:   envDuration("JWT_KEY_OVERLAP", defaultKeyOverlap),
+		kek:       kek,
+	}
+}
+
+// Check makes sure tokens can be signed, e.g. that an asymmetric algorithm has
+// its key encryption key, so a bad configuration fails at startup and not at login
+func (r *KeyRing) Check(ctx context.Context) error {
+	if r.Symmetric() {
+		return nil
+	}
+	_, err := r.SigningKey(ctx)
+	return err
+}
+
+// keyEncryptionKey returns the AES-256 key of JWT_KEY_ENCRYPTION_KEY, nil when unset or invalid
+func keyEncryptionKey() []byte {
+	kek, err := base64.StdEncoding.DecodeString(os.Getenv("JWT_KEY_ENCRYPTION_KEY"))
+	if err != nil || len(kek) != 32 {
+		return nil
+	}
+	return kek
+}
+
+func envDuration(name string, fallback time.Duration) time.Duration {
+	value, err := time.ParseDuration(os.Getenv(name))
+	if err != nil || value <= 0 {
+		return fallback
+	}
+	return value
+}
+
+// Algorithm returns the configured signing algorithm
+func (r *KeyRing) Algorithm() string {
+	return r.algorithm
+}
+
+// Symmetric tells whether tokens are signed with the shared SECRET_KEY
+func (r *KeyRing) Symmetric() bool {
+	return r.algorithm == "HS256"
+}
+
+// refresh reloads the keys from the database when they are older than keyRefresh
+func (r *KeyRing) refresh(ctx context.Context, force bool) error {
+	if !force && time.Since(r.refreshedAt) < keyRefresh {
+		return nil
+	}
+
+	filter := bson.M{"alg": r.algorithm, "verify_until": bson.M{"$gt": time.Now()}}
+	opts := options.Find().SetSort(bson.M{"created_at": -1})
+	cur, err := signingKeyCollection.Find(ctx, filter, opts)
+	if err != nil {
+		return err
+	}
+	defer cur.Close(ctx)
+
+	var keys []*SigningKey
+	if err := cur.All(ctx, &keys); err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err := r.openKey(key); err != nil {
+			return err
+		}
+	}
+
+	r.keys = keys
+	r.refreshedAt = time.Now()
+	return nil
+}
+
+// current returns the newest key allowed to sign now
+func (r *KeyRing) current() *SigningKey {
+	now := time.Now()
+	for _, key := range r.keys {
+		if !now.Before(key.Sign_from) && now.Before(key.Sign_until) {
+			return key
+		}
+	}
+	return nil
+}
+
+// planned tells whether a key published in advance takes over after key
+func (r *KeyRing) planned(key *SigningKey) bool {
+	for _, next := range r.keys {
+		if next.Sign_from.After(key.Sign_from) {
+			return true
+		}
+	}
+	return false
+}
+
+// SigningKey returns the key to sign with. The next key is published ahead of
+// the rotation, a key is only generated to sign right away when none is left.
+func (r *KeyRing) SigningKey(ctx context.Context) (*SigningKey, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.refresh(ctx, false); err != nil {
+		return nil, err
+	}
+	key := r.current()
+	if key == nil {
+		// nothing was published before, so there is no verifier to wait for
+		return r.generate(ctx, time.Now())
+	}
+
+	if time.Until(key.Sign_until) < keyPlanAhead && !r.planned(key) {
+		if _, err := r.planNext(ctx, key.Sign_until); err != nil {
+			log.Printf("publishing the next signing key: %v", err)
+		}
+	}
+	return key, nil
+}
+
+// planNext publishes a key signing from the later of from and the end of the
+// announcement. Keys signing now keep signing until it takes over.
+func (r *KeyRing) planNext(ctx context.Context, from time.Time) (*SigningKey, error) {
+	if announced := time.Now().Add(keyAnnounce); from.Before(announced) {
+		from = announced
+	}
+
+	_, err := signingKeyCollection.UpdateMany(ctx,
+		bson.M{"alg": r.algorithm, "sign_from": bson.M{"$not": bson.M{"$gte": from}}, "sign_until": bson.M{"$gt": time.Now()}},
+		bson.M{"$set": bson.M{"sign_until": from, "verify_until": from.Add(r.overlap)}},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return r.generate(ctx, from)
+}
+
+// VerificationKey returns the public key of kid, published or retired within the overlap window
+func (r *KeyRing) VerificationKey(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.refresh(ctx, false); err != nil {
+		return nil, err
+	}
+	if key := r.find(kid); key != nil {
+		return key.private.Public(), nil
+	}
+
+	// another instance may have rotated in the meantime
+	if time.Since(r.forcedAt) < keyForcedRefresh {
+		return nil, ErrUnknownSigningKey
+	}
+	r.forcedAt = time.Now()
+	if err := r.refresh(ctx, true); err != nil {
+		return nil, err
+	}
+	if key := r.find(kid); key != nil {
+		return key.private.Public(), nil
+	}
+	return nil, ErrUnknownSigningKey
+}
+
+func (r *KeyRing) find(kid string) *SigningKey {
+	for _, key := range r.keys {
+		if key.Kid == kid && time.Now().Before(key.Verify_until) {
+			return key
+		}
+	}
+	return nil
+}
+
+// Rotate publishes a new signing key, it replaces the current keys once
+// verifiers had time to fetch it. The previous keys keep verifying until their overlap ends.
+func (r *KeyRing) Rotate(ctx context.Context) (*SigningKey, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.Symmetric() {
+		return nil, errors.New("HS256 uses SECRET_KEY and cannot be rotated")
+	}
+	return r.planNext(ctx, time.Now())
+}
+
+// generate stores a new key signing from from and reloads the keys
+func (r *KeyRing) generate(ctx context.Context, from time.Time) (*SigningKey, error) {
+	if r.kek == nil {
+		return nil, ErrNoKeyEncryptionKey
+	}
+
+	private, err := generatePrivateKey(r.algorithm)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return nil, err
+	}
+
+	kid := uuid.New().String()
+	sealed, err := sealPrivateKey(r.kek, kid, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+	if err != nil {
+		return nil, err
+	}
+
+	key := &SigningKey{
+		Kid:          kid,
+		Alg:          r.algorithm,
+		Private_key:  sealed,
+		Created_at:   time.Now(),
+		Sign_from:    from,
+		Sign_until:   from.Add(r.rotation),
+		Verify_until: from.Add(r.rotation + r.overlap),
+	}
+	if _, err := signingKeyCollection.InsertOne(ctx, key); err != nil {
+		return nil, err
+	}
+	key.private = private
+
+	if err := r.refresh(ctx, true); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// JWKS returns the public keys that may have signed a valid token
+func (r *KeyRing) JWKS(ctx context.Context) ([]JWK, error) {
+	if r.Symmetric() {
+		return []JWK{}, nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.refresh(ctx, false); err != nil {
+		return nil, err
+	}
+
+	jwks := []JWK{}
+	for _, key := range r.keys {
+		if jwk, ok := NewJWK(key.Kid, key.Alg, key.private.Public()); ok {
+			jwks = append(jwks, jwk)
+		}
+	}
+	return jwks, nil
+}
+
+// NewJWK encodes an RSA or Ed25519 public key
+func NewJWK(kid, alg string, key crypto.PublicKey) (JWK, bool) {
+	jwk := JWK{Kid: kid, Use: "sig", Alg: alg}
+	switch public := key.(type) {
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+	case ed25519.PublicKey:
+		jwk.Kty = "OKP"
+		jwk.Crv = "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(public)
+	default:
+		return jwk, false
+	}
+	return jwk, true
+}
+
+// StartKeyRotation publishes the next signing key ahead of the rotation, checking every interval until ctx is done
+func (r *KeyRing) StartKeyRotation(ctx context.Context, interval time.Duration) {
+	if r.Symmetric() {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if _, err := r.SigningKey(ctx); err != nil {
+					log.Printf("rotating signing keys: %v", err)
+				}
+				// drop the keys past their overlap
+				if _, err := signingKeyCollection.DeleteMany(ctx, bson.M{"verify_until": bson.M{"$lt": time.Now()}}); err != nil {
+					log.Printf("removing expired signing keys: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+func generatePrivateKey(algorithm string) (crypto.Signer, error) {
+	if algorithm == "EdDSA" {
+		_, private, err := ed25519.GenerateKey(rand.Reader)
+		return private, err
+	}
+	return rsa.GenerateKey(rand.Reader, 2048)
+}
+
+// openKey decrypts and parses the private key of a stored key
+func (r *KeyRing) openKey(key *SigningKey) error {
+	if r.kek == nil {
+		return ErrNoKeyEncryptionKey
+	}
+
+	privatePEM, err := openPrivateKey(r.kek, key.Kid, key.Private_key)
+	if err != nil {
+		return err
+	}
+	key.private, err = parsePrivateKey(privatePEM)
+	return err
+}
+
+// sealPrivateKey encrypts a private key with AES-256-GCM, bound to its kid
+func sealPrivateKey(kek []byte, kid string, privatePEM []byte) (string, error) {
+	aead, err := keyCipher(kek)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, privatePEM, []byte(kid))
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func openPrivateKey(kek []byte, kid, sealed string) ([]byte, error) {
+	aead, err := keyCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+	data, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil || len(data) < aead.NonceSize() {
+		return nil, errors.New("invalid signing key")
+	}
+	return aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], []byte(kid))
+}
+
+func keyCipher(kek []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func parsePrivateKey(privatePEM []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(privatePEM)
+	if block == nil {
+		return nil, errors.New("invalid signing key")
+	}
+
+	private, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := private.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("invalid signing key")
+	}
+	return signer, nil
+}
@@ -0,0 +1,87 @@
+package helpers
+
+import (
+	"context"
+	"encoding/base64"
+	"testing"
+	"time"
+)
+
+func TestNewKeyRingDefaultAlgorithm(t *testing.T) {
+	kek := base64.StdEncoding.EncodeToString(make([]byte, 32))
+	cases := map[string]struct{ algorithm, kek, want string }{
+		"nothing set":        {"", "", "HS256"},
+		"key encryption key": {"", kek, "RS256"},
+		"explicit EdDSA":     {"EdDSA", kek, "EdDSA"},
+		"explicit HS256":     {"HS256", kek, "HS256"},
+		"unknown algorithm":  {"none", "", "RS256"},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv("JWT_ALGORITHM", tc.algorithm)
+			t.Setenv("JWT_KEY_ENCRYPTION_KEY", tc.kek)
+			if got := NewKeyRing().Algorithm(); got != tc.want {
+				t.Fatalf("algorithm %s, want %s", got, tc.want)
+			}
+		})
+	}
+}
+
+// an RS256 ring without a key encryption key cannot sign, Check reports it at startup
+func TestCheckRequiresKeyEncryptionKey(t *testing.T) {
+	t.Setenv("JWT_ALGORITHM", "RS256")
+	t.Setenv("JWT_KEY_ENCRYPTION_KEY", "")
+
+	ring := NewKeyRing()
+	ring.refreshedAt = time.Now()
+	if err := ring.Check(context.Background()); err != ErrNoKeyEncryptionKey {
+		t.Fatalf("got %v, want ErrNoKeyEncryptionKey", err)
+	}
+}
+
+func TestAnnouncedKeySignsLater(t *testing.T) {
+	active := useKeyRing(t, "RS256")
+	private, err := generatePrivateKey("RS256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	next := &SigningKey{
+		Kid:          "next-key",
+		Alg:          "RS256",
+		Created_at:   time.Now(),
+		Sign_from:    time.Now().Add(keyAnnounce),
+		Sign_until:   time.Now().Add(keyAnnounce + time.Hour),
+		Verify_until: time.Now().Add(keyAnnounce + 2*time.Hour),
+		private:      private,
+	}
+	// newest first, like refresh
+	Keys.keys = []*SigningKey{next, active}
+
+	ctx := context.Background()
+	key, err := Keys.SigningKey(ctx)
+	if err != nil || key.Kid != active.Kid {
+		t.Fatalf("signing with %v, %v", key, err)
+	}
+
+	jwks, err := Keys.JWKS(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	published := map[string]bool{}
+	for _, jwk := range jwks {
+		published[jwk.Kid] = true
+	}
+	if !published[active.Kid] || !published[next.Kid] {
+		t.Fatalf("published %v", published)
+	}
+	if _, err := Keys.VerificationKey(ctx, next.Kid); err != nil {
+		t.Fatalf("next key does not verify: %v", err)
+	}
+
+	// once the announcement is over the next key takes over
+	next.Sign_from = time.Now().Add(-time.Second)
+	if key := Keys.current(); key != next {
+		t.Fatalf("signing with %s after the announcement", key.Kid)
+	}
+}
@@ -1,22 +1,96 @@
 package helpers
 
 import (
-	"fmt"
+	"context"
+	"errors"
 	"os"
+	"time"
 
-	"github.com/dgrijalva/jwt-go"
+	"github.com/golang-jwt/jwt/v5"
 )
 
+// SignedDetails are the claims of every token issued by the API
 type SignedDetails struct {
-	ID string
-	jwt.StandardClaims
+	UserID       string   `json:"id"`
+	RoleType     string   `json:"roleType,omitempty"`
+	SessionID    string   `json:"sid,omitempty"`
+	Permissions  []string `json:"permissions,omitempty"`
+	Scope        string   `json:"scope,omitempty"`
+	TokenVersion int      `json:"tv"`
+	jwt.RegisteredClaims
 }
 
 var SECRET_KEY string = os.Getenv("SECRET_KEY")
 
-func GenerateAllTokens(claims *jwt.MapClaims) (string, error) {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	webtoken, err := token.SignedString([]byte(SECRET_KEY))
+// scopes of tokens that only grant one step of the two-factor login
+const (
+	ScopeMfaChallenge = "mfa_challenge"
+	ScopeMfaEnroll    = "mfa_enroll"
+)
+
+var ErrMissingClaims = errors.New("token is missing required claims")
+
+// registered claims checked on every token, JWT_ISSUER and JWT_AUDIENCE
+// must match between the services sharing the JWKS
+var (
+	tokenIssuer   = envString("JWT_ISSUER", "gin-api")
+	tokenAudience = envString("JWT_AUDIENCE", "gin-api")
+)
+
+// clock skew tolerated between the services checking exp, nbf and iat
+const tokenLeeway = 30 * time.Second
+
+func envString(name, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// Validate is called by the parser once the registered claims are checked
+func (claims *SignedDetails) Validate() error {
+	if claims.UserID == "" || claims.IssuedAt == nil || (claims.RoleType == "" && claims.Scope == "") {
+		return ErrMissingClaims
+	}
+	return nil
+}
+
+// HasPermission tells whether the token was granted the permission
+func (claims *SignedDetails) HasPermission(permission string) bool {
+	for _, p := range claims.Permissions {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// GenerateAllTokens signs the claims with the current key of the key ring,
+// adding the iss, aud, iat and nbf claims. The caller sets the expiry.
+func GenerateAllTokens(claims *SignedDetails) (string, error) {
+	if claims.ExpiresAt == nil {
+		return "", ErrMissingClaims
+	}
+
+	now := jwt.NewNumericDate(time.Now())
+	claims.Issuer = tokenIssuer
+	claims.Audience = jwt.ClaimStrings{tokenAudience}
+	claims.IssuedAt = now
+	claims.NotBefore = now
+
+	if Keys.Symmetric() {
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+		return token.SignedString([]byte(SECRET_KEY))
+	}
+
+	key, err := Keys.SigningKey(context.Background())
+	if err != nil {
+		return "", err
+	}
+
+	token := jwt.NewWithClaims(jwt.GetSigningMethod(key.Alg), claims)
+	token.Header["kid"] = key.Kid
+	webtoken, err := token.SignedString(key.private)
 	if err != nil {
 		return "", err
 	}
@@ -25,29 +99,34 @@ func GenerateAllTokens(claims *jwt.MapClaims) (string, error) {
 }
 
 func ValidateToken(tokenString string) (*jwt.Token, error) {
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		if _, isValid := token.Method.(*jwt.SigningMethodHMAC); !isValid {
-			return nil, fmt.Errorf("unexpected signing method : %v", token.Header["alg"])
+	return jwt.ParseWithClaims(tokenString, &SignedDetails{}, func(token *jwt.Token) (interface{}, error) {
+		if Keys.Symmetric() {
+			return []byte(SECRET_KEY), nil
 		}
-		return []byte(SECRET_KEY), nil
-	})
 
-	if err != nil {
-		return nil, err
-	}
-	return token, err
+		kid, _ := token.Header["kid"].(string)
+		return Keys.VerificationKey(context.Background(), kid)
+	},
+		// only the configured algorithm is accepted, never the one chosen by the token
+		jwt.WithValidMethods([]string{Keys.Algorithm()}),
+		jwt.WithIssuer(tokenIssuer),
+		jwt.WithAudience(tokenAudience),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(tokenLeeway),
+	)
 }
 
-func DecodeToken(tokenString string) (jwt.MapClaims, error) {
+func DecodeToken(tokenString string) (*SignedDetails, error) {
 	token, err := ValidateToken(tokenString)
 	if err != nil {
 		return nil, err
 	}
 
-	claims, isOk := token.Claims.(jwt.MapClaims)
+	claims, isOk := token.Claims.(*SignedDetails)
 	if isOk && token.Valid {
 		return claims, nil
 	}
 
-	return nil, fmt.Errorf("invalid token")
+	return nil, errors.New("invalid token")
 }
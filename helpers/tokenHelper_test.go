@@ -0,0 +1,278 @@
+package helpers
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var tokenAlgorithms = []string{"HS256", "RS256", "EdDSA"}
+
+// useKeyRing replaces Keys by a ring of the algorithm holding one fresh key,
+// so no token test needs the database
+func useKeyRing(t *testing.T, algorithm string) *SigningKey {
+	t.Helper()
+
+	previousKeys, previousSecret := Keys, SECRET_KEY
+	t.Cleanup(func() {
+		Keys, SECRET_KEY = previousKeys, previousSecret
+	})
+
+	SECRET_KEY = "test-secret"
+	Keys = &KeyRing{algorithm: algorithm, rotation: time.Hour, overlap: time.Hour, refreshedAt: time.Now()}
+	if Keys.Symmetric() {
+		return nil
+	}
+
+	private, err := generatePrivateKey(algorithm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := &SigningKey{
+		Kid:          "test-key",
+		Alg:          algorithm,
+		Created_at:   time.Now(),
+		Sign_from:    time.Now(),
+		Sign_until:   time.Now().Add(keyPlanAhead + time.Hour),
+		Verify_until: time.Now().Add(keyPlanAhead + 2*time.Hour),
+		private:      private,
+	}
+	Keys.keys = []*SigningKey{key}
+	return key
+}
+
+// validClaims are the claims GenerateAllTokens would sign for a login
+func validClaims() *SignedDetails {
+	now := time.Now()
+	claims := &SignedDetails{UserID: "user-1", RoleType: "admin", SessionID: "session-1"}
+	claims.Issuer = tokenIssuer
+	claims.Audience = jwt.ClaimStrings{tokenAudience}
+	claims.IssuedAt = jwt.NewNumericDate(now)
+	claims.NotBefore = jwt.NewNumericDate(now)
+	claims.ExpiresAt = jwt.NewNumericDate(now.Add(time.Hour))
+	return claims
+}
+
+// signWith signs claims with the key of the ring like GenerateAllTokens, but
+// without filling in any claim
+func signWith(t *testing.T, key *SigningKey, claims jwt.Claims) string {
+	t.Helper()
+
+	var token string
+	var err error
+	if key == nil {
+		token, err = jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(SECRET_KEY))
+	} else {
+		unsigned := jwt.NewWithClaims(jwt.GetSigningMethod(key.Alg), claims)
+		unsigned.Header["kid"] = key.Kid
+		token, err = unsigned.SignedString(key.private)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestGeneratedTokenDecodes(t *testing.T) {
+	for _, algorithm := range tokenAlgorithms {
+		t.Run(algorithm, func(t *testing.T) {
+			useKeyRing(t, algorithm)
+
+			claims := &SignedDetails{UserID: "user-1", RoleType: "admin", TokenVersion: 3}
+			claims.ExpiresAt = jwt.NewNumericDate(time.Now().Add(time.Hour))
+			token, err := GenerateAllTokens(claims)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := ValidateToken(token); err != nil {
+				t.Fatalf("ValidateToken: %v", err)
+			}
+			decoded, err := DecodeToken(token)
+			if err != nil {
+				t.Fatalf("DecodeToken: %v", err)
+			}
+			if decoded.UserID != "user-1" || decoded.RoleType != "admin" || decoded.TokenVersion != 3 {
+				t.Fatalf("decoded claims %+v", decoded)
+			}
+		})
+	}
+}
+
+func TestGenerateRequiresExpiry(t *testing.T) {
+	useKeyRing(t, "HS256")
+
+	if _, err := GenerateAllTokens(&SignedDetails{UserID: "user-1", RoleType: "admin"}); !errors.Is(err, ErrMissingClaims) {
+		t.Fatalf("got %v, want ErrMissingClaims", err)
+	}
+}
+
+func TestExpiredToken(t *testing.T) {
+	for _, algorithm := range tokenAlgorithms {
+		t.Run(algorithm, func(t *testing.T) {
+			key := useKeyRing(t, algorithm)
+
+			claims := validClaims()
+			claims.IssuedAt = jwt.NewNumericDate(time.Now().Add(-2 * time.Hour))
+			claims.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Hour))
+			token := signWith(t, key, claims)
+
+			if _, err := ValidateToken(token); !errors.Is(err, jwt.ErrTokenExpired) {
+				t.Fatalf("ValidateToken: got %v, want ErrTokenExpired", err)
+			}
+			if _, err := DecodeToken(token); err == nil {
+				t.Fatal("DecodeToken accepted an expired token")
+			}
+		})
+	}
+}
+
+func TestExpiryWithinLeeway(t *testing.T) {
+	key := useKeyRing(t, "RS256")
+
+	claims := validClaims()
+	claims.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-tokenLeeway / 2))
+	if _, err := DecodeToken(signWith(t, key, claims)); err != nil {
+		t.Fatalf("DecodeToken refused a token expired within the leeway: %v", err)
+	}
+}
+
+func TestWrongAlgorithm(t *testing.T) {
+	for _, algorithm := range tokenAlgorithms {
+		t.Run(algorithm, func(t *testing.T) {
+			useKeyRing(t, algorithm)
+
+			// a token of another algorithm, signed with a key the ring never held
+			other := "RS256"
+			if algorithm == "RS256" {
+				other = "EdDSA"
+			}
+			private, err := generatePrivateKey(other)
+			if err != nil {
+				t.Fatal(err)
+			}
+			foreign := &SigningKey{Kid: "test-key", Alg: other, private: private}
+
+			tokens := map[string]string{
+				other: signWith(t, foreign, validClaims()),
+				"none": func() string {
+					token, err := jwt.NewWithClaims(jwt.SigningMethodNone, validClaims()).SignedString(jwt.UnsafeAllowNoneSignatureType)
+					if err != nil {
+						t.Fatal(err)
+					}
+					return token
+				}(),
+			}
+			if algorithm != "HS256" {
+				// HMAC keyed with the secret cannot pass for an asymmetric signature
+				tokens["HS256"] = signWith(t, nil, validClaims())
+			}
+
+			for name, token := range tokens {
+				if _, err := ValidateToken(token); !errors.Is(err, jwt.ErrTokenSignatureInvalid) {
+					t.Errorf("ValidateToken(%s): got %v, want ErrTokenSignatureInvalid", name, err)
+				}
+				if _, err := DecodeToken(token); err == nil {
+					t.Errorf("DecodeToken accepted a %s token", name)
+				}
+			}
+		})
+	}
+}
+
+func TestTamperedSignature(t *testing.T) {
+	for _, algorithm := range tokenAlgorithms {
+		t.Run(algorithm, func(t *testing.T) {
+			key := useKeyRing(t, algorithm)
+			token := signWith(t, key, validClaims())
+			parts := strings.Split(token, ".")
+
+			// same header and signature, a payload claiming another role
+			forged := validClaims()
+			forged.UserID = "user-2"
+			payload := strings.Split(signWith(t, key, forged), ".")[1]
+
+			signature := []byte(parts[2])
+			if signature[0] == 'A' {
+				signature[0] = 'B'
+			} else {
+				signature[0] = 'A'
+			}
+
+			tampered := map[string]string{
+				"payload":   parts[0] + "." + payload + "." + parts[2],
+				"signature": parts[0] + "." + parts[1] + "." + string(signature),
+				"stripped":  parts[0] + "." + parts[1] + ".",
+			}
+			for name, token := range tampered {
+				if _, err := ValidateToken(token); err == nil {
+					t.Errorf("ValidateToken accepted a token with a tampered %s", name)
+				}
+				if _, err := DecodeToken(token); err == nil {
+					t.Errorf("DecodeToken accepted a token with a tampered %s", name)
+				}
+			}
+		})
+	}
+}
+
+func TestUnknownKeyID(t *testing.T) {
+	for _, algorithm := range []string{"RS256", "EdDSA"} {
+		t.Run(algorithm, func(t *testing.T) {
+			key := useKeyRing(t, algorithm)
+			retired := *key
+			retired.Kid = "retired-key"
+			retired.Verify_until = time.Now().Add(-time.Minute)
+			Keys.keys = append(Keys.keys, &retired)
+			// a forced reload would reach the database
+			Keys.forcedAt = time.Now()
+
+			if _, err := DecodeToken(signWith(t, &retired, validClaims())); !errors.Is(err, ErrUnknownSigningKey) {
+				t.Fatalf("got %v, want ErrUnknownSigningKey", err)
+			}
+		})
+	}
+}
+
+func TestMissingClaims(t *testing.T) {
+	cases := map[string]func(*SignedDetails){
+		"id":       func(c *SignedDetails) { c.UserID = "" },
+		"role":     func(c *SignedDetails) { c.RoleType = "" },
+		"iat":      func(c *SignedDetails) { c.IssuedAt = nil },
+		"exp":      func(c *SignedDetails) { c.ExpiresAt = nil },
+		"iss":      func(c *SignedDetails) { c.Issuer = "" },
+		"aud":      func(c *SignedDetails) { c.Audience = nil },
+		"wrongIss": func(c *SignedDetails) { c.Issuer = "another-service" },
+		"wrongAud": func(c *SignedDetails) { c.Audience = jwt.ClaimStrings{"another-service"} },
+	}
+
+	for _, algorithm := range tokenAlgorithms {
+		t.Run(algorithm, func(t *testing.T) {
+			key := useKeyRing(t, algorithm)
+
+			for name, remove := range cases {
+				claims := validClaims()
+				remove(claims)
+				token := signWith(t, key, claims)
+
+				if _, err := ValidateToken(token); err == nil {
+					t.Errorf("ValidateToken accepted a token without %s", name)
+				}
+				if _, err := DecodeToken(token); err == nil {
+					t.Errorf("DecodeToken accepted a token without %s", name)
+				}
+			}
+
+			// two-factor step tokens carry a scope instead of a role
+			claims := validClaims()
+			claims.RoleType = ""
+			claims.Scope = ScopeMfaChallenge
+			if _, err := DecodeToken(signWith(t, key, claims)); err != nil {
+				t.Errorf("DecodeToken refused a scoped token: %v", err)
+			}
+		})
+	}
+}
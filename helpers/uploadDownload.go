@@ -1,22 +1,109 @@
 package helpers
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
+	"gin-api/responses"
 	"io"
+	"mime"
 	"mime/multipart"
 	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/minio/minio-go/v7"
-	"github.com/minio/minio-go/v7/pkg/credentials"
 )
 
 var ErrInvalidFileExtension = errors.New("invalid file extension")
+var ErrInvalidImage = errors.New("invalid image")
+
+// ImageExtensions maps the accepted image content types to their extension
+var ImageExtensions = map[string]string{
+	"image/png":  ".png",
+	"image/jpeg": ".jpg",
+}
+
+const defaultMaxUploadSize = 10 << 20
+
+const (
+	// content addressed objects never change, so browsers may keep them forever
+	immutableCacheControl = "public, max-age=31536000, immutable"
+	mutableCacheControl   = "public, max-age=300, must-revalidate"
+	privateCacheControl   = "private, max-age=300"
+)
+
+// ContentAddressedName names an upload after the sha256 of its content,
+// keeping the original extension
+func ContentAddressedName(imageData *multipart.FileHeader) (string, error) {
+	fileData, err := imageData.Open()
+	if err != nil {
+		return "", err
+	}
+	defer fileData.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, fileData); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)) + strings.ToLower(filepath.Ext(imageData.Filename)), nil
+}
+
+// MaxUploadSize is the largest accepted image in bytes, set by UPLOAD_MAX_SIZE
+func MaxUploadSize() int64 {
+	size, err := strconv.ParseInt(os.Getenv("UPLOAD_MAX_SIZE"), 10, 64)
+	if err != nil || size <= 0 {
+		return defaultMaxUploadSize
+	}
+	return size
+}
+
+// DetectImageType sniffs the content type of the image data and
+// returns ErrInvalidImage when it is not an accepted image
+func DetectImageType(reader io.Reader) (string, error) {
+	head := make([]byte, 512)
+	n, err := io.ReadFull(reader, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+
+	contentType := http.DetectContentType(head[:n])
+	if _, ok := ImageExtensions[contentType]; !ok {
+		return "", ErrInvalidImage
+	}
+	return contentType, nil
+}
+
+// ContentAddressedData checks that data is an accepted image of at most
+// MaxUploadSize bytes and returns its content addressed name and content type
+func ContentAddressedData(data []byte) (string, string, error) {
+	if int64(len(data)) > MaxUploadSize() {
+		return "", "", ErrInvalidImage
+	}
+	contentType, err := DetectImageType(bytes.NewReader(data))
+	if err != nil {
+		return "", "", err
+	}
+
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]) + ImageExtensions[contentType], contentType, nil
+}
+
+func isContentAddressed(objectName string) bool {
+	name := strings.TrimSuffix(filepath.Base(objectName), filepath.Ext(objectName))
+	if len(name) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(name)
+	return err == nil
+}
 
 func UploadImageToMinio(imageData *multipart.FileHeader, objectName string) error {
 	// Check if the file has a valid extension (PNG or JPG)
@@ -25,63 +112,67 @@ func UploadImageToMinio(imageData *multipart.FileHeader, objectName string) erro
 		return ErrInvalidFileExtension
 	}
 
-	// Implementasi koneksi ke MinIO
-	endpoint := os.Getenv("MINIO_ENDPOINT")
-	accessKey := os.Getenv("MINIO_ACCESS_KEY_ID")
-	secretKey := os.Getenv("MINIO_SECRET_ACCESS_KEY_ID")
-	bucketName := "gin-api"
-
 	fileData, err := imageData.Open()
 	if err != nil {
 		return err
 	}
 	defer fileData.Close()
 
-	minioClient, err := minio.New(endpoint, &minio.Options{
-		Creds:  credentials.NewStaticV4(accessKey, secretKey, ""),
-		Secure: false, // Ganti menjadi true jika menggunakan koneksi aman (HTTPS)
-	})
+	// the type the client sent is not trusted, the object is served with the sniffed one
+	contentType, err := DetectImageType(fileData)
 	if err != nil {
 		return err
 	}
+	if _, err := fileData.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
 
 	// Upload file content ke MinIO
-	_, err = minioClient.PutObject(context.Background(), bucketName, objectName, fileData, imageData.Size, minio.PutObjectOptions{})
+	return Blobs.Put(context.Background(), objectName, fileData, imageData.Size, contentType)
+}
+
+// UploadContentAddressedImage uploads the image below prefix under its content
+// addressed name and returns that name
+func UploadContentAddressedImage(imageData *multipart.FileHeader, prefix string) (string, error) {
+	objectName, err := ContentAddressedName(imageData)
 	if err != nil {
-		return err
+		return "", err
 	}
+	objectName = prefix + objectName
 
-	return nil
+	if err := UploadImageToMinio(imageData, objectName); err != nil {
+		return "", err
+	}
+	return objectName, nil
 }
 
+// DownloadImage redirects to a presigned MinIO URL once the caller may read the object
 func DownloadImage(c *gin.Context) {
-	// Implementasi koneksi ke MinIO
-	endpoint := os.Getenv("MINIO_ENDPOINT")
-	accessKey := os.Getenv("MINIO_ACCESS_KEY_ID")
-	secretKey := os.Getenv("MINIO_SECRET_ACCESS_KEY_ID")
-
-	filename := c.Param("filename")
-	bucketName := "gin-api"
-	objectName := filename
-
-	minioClient, err := minio.New(endpoint, &minio.Options{
-		Creds:  credentials.NewStaticV4(accessKey, secretKey, ""),
-		Secure: false, // Ganti menjadi true jika menggunakan koneksi aman (HTTPS)
-	})
+	objectName := strings.TrimPrefix(c.Param("filename"), "/")
+
+	visibility, ok := authorizeAsset(c, objectName)
+	if !ok {
+		return
+	}
+
+	minioClient, err := newMinioClient()
 	if err != nil {
 		c.Error(err)
-		c.AbortWithStatus(http.StatusInternalServerError)
+		responses.Abort(c, responses.InternalError, "Error creating download URL")
 		return
 	}
 
 	// Set expiration duration for the presigned URL
 	expiration := 24 * time.Hour // You can adjust the expiration duration as needed
+	if visibility == VisibilityPrivate {
+		expiration = signedURLExpiration
+	}
 
 	// Create a presigned URL for the image
 	url, err := minioClient.PresignedGetObject(context.Background(), bucketName, objectName, expiration, url.Values{})
 	if err != nil {
 		c.Error(err)
-		c.AbortWithStatus(http.StatusInternalServerError)
+		responses.Abort(c, responses.InternalError, "Error creating download URL")
 		return
 	}
 
@@ -89,40 +180,61 @@ func DownloadImage(c *gin.Context) {
 	c.Redirect(http.StatusTemporaryRedirect, url.String())
 }
 
+// ShowImageFromMinio serves the image with validators and cache headers,
+// answering conditional requests with 304 and ranged requests with 206
 func ShowImageFromMinio(c *gin.Context) {
-	endpoint := os.Getenv("MINIO_ENDPOINT")
-	accessKey := os.Getenv("MINIO_ACCESS_KEY_ID")
-	secretKey := os.Getenv("MINIO_SECRET_ACCESS_KEY_ID")
-
-	filename := c.Param("filename")
-	bucketName := "gin-api"
-	objectName := filename
-
-	minioClient, err := minio.New(endpoint, &minio.Options{
-		Creds:  credentials.NewStaticV4(accessKey, secretKey, ""),
-		Secure: false, // Ganti menjadi true jika menggunakan koneksi aman (HTTPS)
-	})
+	objectName := strings.TrimPrefix(c.Param("filename"), "/")
+
+	visibility, ok := authorizeAsset(c, objectName)
+	if !ok {
+		return
+	}
+
+	info, err := Blobs.Stat(c.Request.Context(), objectName)
+	if errors.Is(err, ErrBlobNotFound) {
+		responses.Error(c, responses.ImageNotFound, "Image not found")
+		return
+	}
 	if err != nil {
-		c.Error(err)
-		c.AbortWithStatus(http.StatusInternalServerError)
+		responses.Error(c, responses.InternalError, "Internal Server Error")
 		return
 	}
 
 	// Fetch the object from Minio
-	object, err := minioClient.GetObject(context.Background(), bucketName, objectName, minio.GetObjectOptions{})
+	object, err := Blobs.Get(c.Request.Context(), objectName, "")
+	if errors.Is(err, ErrBlobNotFound) {
+		responses.Error(c, responses.ImageNotFound, "Image not found")
+		return
+	}
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+		responses.Error(c, responses.InternalError, "Internal Server Error")
 		return
 	}
 	defer object.Close()
 
-	// Set the appropriate headers for an image response
-	c.Header("Content-Type", "image/jpeg") // Adjust the content type based on your image type
+	// only image types are served, objects stored with another type use their extension
+	contentType := info.ContentType
+	if _, ok := ImageExtensions[contentType]; !ok {
+		contentType = mime.TypeByExtension(filepath.Ext(objectName))
+	}
+	if _, ok := ImageExtensions[contentType]; !ok {
+		contentType = "image/jpeg"
+	}
 
-	// Stream the object to the client
-	_, err = io.Copy(c.Writer, object)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
-		return
+	cacheControl := mutableCacheControl
+	if visibility == VisibilityPrivate {
+		cacheControl = privateCacheControl
+	} else if isContentAddressed(objectName) {
+		cacheControl = immutableCacheControl
 	}
+
+	c.Header("Content-Type", contentType)
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.Header("Cache-Control", cacheControl)
+	if info.ETag != "" {
+		c.Header("ETag", `"`+info.ETag+`"`)
+	}
+
+	// ServeContent handles If-None-Match, If-Modified-Since and Range
+	http.ServeContent(c.Writer, c.Request, objectName, info.LastModified, object)
 }
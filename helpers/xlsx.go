@@ -0,0 +1,133 @@
+package helpers
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// XLSXContentType is the media type of Excel workbooks
+const XLSXContentType = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+
+// the parts of a workbook with a single sheet, written before the sheet itself
+var xlsxParts = []struct{ name, content string }{
+	{"[Content_Types].xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+		`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+		`<Default Extension="xml" ContentType="application/xml"/>` +
+		`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+		`<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>` +
+		`</Types>`},
+	{"_rels/.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+		`</Relationships>`},
+	{"xl/_rels/workbook.xml.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>` +
+		`</Relationships>`},
+}
+
+// XLSXWriter streams the rows of a single sheet workbook, nothing but the
+// current row is kept in memory
+type XLSXWriter struct {
+	archive *zip.Writer
+	sheet   io.Writer
+	row     int
+}
+
+// NewXLSXWriter writes the workbook parts to w and opens the sheet
+func NewXLSXWriter(w io.Writer, sheetName string) (*XLSXWriter, error) {
+	archive := zip.NewWriter(w)
+	for _, part := range xlsxParts {
+		if err := writeXLSXPart(archive, part.name, part.content); err != nil {
+			return nil, err
+		}
+	}
+
+	workbook, err := archive.Create("xl/workbook.xml")
+	if err != nil {
+		return nil, err
+	}
+	io.WriteString(workbook, `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets><sheet name="`)
+	xml.EscapeText(workbook, []byte(sheetName))
+	if _, err := io.WriteString(workbook, `" sheetId="1" r:id="rId1"/></sheets></workbook>`); err != nil {
+		return nil, err
+	}
+
+	sheet, err := archive.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.WriteString(sheet, `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`); err != nil {
+		return nil, err
+	}
+
+	return &XLSXWriter{archive: archive, sheet: sheet}, nil
+}
+
+func writeXLSXPart(archive *zip.Writer, name, content string) error {
+	part, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(part, content)
+	return err
+}
+
+// WriteRow appends a row, integers and floats become number cells, times are
+// written as RFC 3339 text and everything else as text
+func (x *XLSXWriter) WriteRow(values []interface{}) error {
+	x.row++
+	row := strconv.Itoa(x.row)
+	io.WriteString(x.sheet, `<row r="`+row+`">`)
+	for i, value := range values {
+		ref := xlsxColumn(i) + row
+		switch value := value.(type) {
+		case nil:
+			continue
+		case int:
+			io.WriteString(x.sheet, `<c r="`+ref+`"><v>`+strconv.Itoa(value)+`</v></c>`)
+		case int64:
+			io.WriteString(x.sheet, `<c r="`+ref+`"><v>`+strconv.FormatInt(value, 10)+`</v></c>`)
+		case float64:
+			io.WriteString(x.sheet, `<c r="`+ref+`"><v>`+strconv.FormatFloat(value, 'f', -1, 64)+`</v></c>`)
+		case time.Time:
+			x.writeText(ref, value.Format(time.RFC3339))
+		case string:
+			x.writeText(ref, value)
+		default:
+			return fmt.Errorf("unsupported cell value %T", value)
+		}
+	}
+	_, err := io.WriteString(x.sheet, `</row>`)
+	return err
+}
+
+func (x *XLSXWriter) writeText(ref, text string) {
+	io.WriteString(x.sheet, `<c r="`+ref+`" t="inlineStr"><is><t xml:space="preserve">`)
+	xml.EscapeText(x.sheet, []byte(text))
+	io.WriteString(x.sheet, `</t></is></c>`)
+}
+
+// Close ends the sheet and the workbook, it does not close the underlying writer
+func (x *XLSXWriter) Close() error {
+	if _, err := io.WriteString(x.sheet, `</sheetData></worksheet>`); err != nil {
+		return err
+	}
+	return x.archive.Close()
+}
+
+// xlsxColumn returns the letters of a zero based column, e.g. 0 is A and 26 is AA
+func xlsxColumn(index int) string {
+	name := ""
+	for index++; index > 0; index = (index - 1) / 26 {
+		name = string(rune('A'+(index-1)%26)) + name
+	}
+	return name
+}
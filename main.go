@@ -2,33 +2,92 @@
 package main
 
 import (
+	"context"
 	"log"
 	"net/http"
 	"os"
+	"strings"
+	"time"
 
+	"gin-api/configs"
+	"gin-api/controllers"
+	"gin-api/helpers"
+	"gin-api/middleware"
 	"gin-api/routes"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 )
 
+func trustedProxies() []string {
+	var proxies []string
+	for _, proxy := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			proxies = append(proxies, proxy)
+		}
+	}
+	return proxies
+}
+
 func main() {
 
+	// Fail fast when the database is unreachable
+	if err := configs.PingDB(); err != nil {
+		log.Fatal(err)
+	}
+
+	// Fail fast when tokens cannot be signed, e.g. RS256 without JWT_KEY_ENCRYPTION_KEY
+	if err := helpers.Keys.Check(context.Background()); err != nil {
+		log.Fatalf("signing keys: %v", err)
+	}
+
+	// Create the indexes the handlers rely on, e.g. unique and expiring ones
+	if err := controllers.EnsureIndexes(context.Background()); err != nil {
+		log.Printf("creating indexes: %v", err)
+	}
+	if err := controllers.FailInterruptedImports(context.Background()); err != nil {
+		log.Printf("failing interrupted imports: %v", err)
+	}
+
 	// Create a new Gin router
 	router := gin.Default()
 
+	// Only trust X-Forwarded-For from the proxies of TRUSTED_PROXIES (comma separated
+	// IPs or CIDRs), the client IP drives API key allowlists and login throttling
+	if err := router.SetTrustedProxies(trustedProxies()); err != nil {
+		log.Fatal(err)
+	}
+
 	// Set up CORS middleware
 	config := cors.DefaultConfig()
 	config.AllowOrigins = []string{"*"} // Update with your allowed origins
-	config.AllowMethods = []string{"GET", "POST", "PUT", "DELETE"}
+	config.AllowMethods = []string{"GET", "POST", "PUT", "PATCH", "DELETE"}
+	// writes send back the ETag of the version they edit
+	config.AddAllowHeaders("If-Match")
+	config.AddExposeHeaders("ETag", middleware.RequestIDHeader)
 	router.Use(cors.New(config))
 
+	// Tag every request with an id, kept in the audit log
+	router.Use(middleware.RequestID())
+
 	// Set up Logger middleware
 	router.Use(gin.Logger())
 
 	// Initialize routes
 	routes.InitRoutes(router)
 
+	// Remove orphaned images periodically, e.g. BLOB_RECONCILE_INTERVAL=24h
+	if interval, err := time.ParseDuration(os.Getenv("BLOB_RECONCILE_INTERVAL")); err == nil && interval > 0 {
+		dryRun := os.Getenv("BLOB_RECONCILE_DRY_RUN") == "true"
+		controllers.StartBlobReconciler(context.Background(), interval, dryRun)
+	}
+
+	// Purge the products and categories deleted more than TRASH_RETENTION ago, with their images
+	controllers.StartTrashPurger(context.Background(), time.Hour)
+
+	// Publish the next signing key before the current one is due, JWT_KEY_ROTATION sets the key lifetime
+	helpers.Keys.StartKeyRotation(context.Background(), time.Hour)
+
 	// Set up server port
 	port := os.Getenv("PORT")
 
@@ -0,0 +1,81 @@
+package middleware
+
+import (
+	"context"
+	"crypto/subtle"
+	"gin-api/configs"
+	"gin-api/helpers"
+	"gin-api/models"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var apiKeyCollection *mongo.Collection = configs.GetCollection(configs.DB, "api_keys")
+
+// requestApiKey returns the key sent as X-API-Key or as "Authorization: ApiKey <key>"
+func requestApiKey(c *gin.Context) string {
+	if key := c.GetHeader("X-API-Key"); key != "" {
+		return key
+	}
+
+	parts := strings.SplitN(c.GetHeader("Authorization"), " ", 2)
+	if len(parts) == 2 && strings.EqualFold(parts[0], "ApiKey") {
+		return parts[1]
+	}
+	return ""
+}
+
+func hasScopes(granted []string, required []string) bool {
+	for _, scope := range required {
+		if !containsScope(granted, scope) {
+			return false
+		}
+	}
+	return true
+}
+
+// authenticateApiKey checks the API key of the request against its scopes, expiry
+// and IP allowlist, records its use and stores the key as apiKey
+func authenticateApiKey(c *gin.Context, scopes []string) bool {
+	key := requestApiKey(c)
+	prefix := helpers.ApiKeyPrefix(key)
+	if prefix == "" || len(scopes) == 0 {
+		return false
+	}
+
+	var apiKey models.ApiKey
+	filter := bson.M{"prefix": prefix, "revoked_at": bson.M{"$exists": false}}
+	if err := apiKeyCollection.FindOne(context.Background(), filter).Decode(&apiKey); err != nil {
+		return false
+	}
+
+	if subtle.ConstantTimeCompare([]byte(apiKey.Hash), []byte(helpers.HashOpaqueToken(key))) != 1 {
+		return false
+	}
+	if apiKey.Expires_at != nil && time.Now().After(*apiKey.Expires_at) {
+		return false
+	}
+	if !helpers.IPAllowed(c.ClientIP(), apiKey.Allowed_ips) || !hasScopes(apiKey.Scopes, scopes) {
+		return false
+	}
+
+	go func(id, ip string) {
+		update := bson.M{"$set": bson.M{"last_used_at": time.Now(), "last_used_ip": ip}}
+		if _, err := apiKeyCollection.UpdateOne(context.Background(), bson.M{"_id": id}, update); err != nil {
+			log.Printf("recording api key use: %v", err)
+		}
+	}(apiKey.ID, c.ClientIP())
+
+	c.Set("apiKey", apiKey)
+	c.Set("userLogin", &helpers.SignedDetails{
+		UserID:      "api_key:" + apiKey.ID,
+		RoleType:    "api_key",
+		Permissions: apiKey.Scopes,
+	})
+	return true
+}
@@ -1,58 +1,148 @@
 package middleware
 
 import (
+	"context"
+	"gin-api/configs"
 	"gin-api/helpers"
-	"net/http"
+	"gin-api/responses"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-type Result struct {
-	Code    int         `json:"code"`
-	Data    interface{} `json:"data"`
-	Message string      `json:"message"`
+var userCollection *mongo.Collection = configs.GetCollection(configs.DB, "users")
+
+func unauthorized(c *gin.Context) {
+	responses.Abort(c, responses.Unauthorized, "Unauthorized")
+}
+
+var sessionCollection *mongo.Collection = configs.GetCollection(configs.DB, "sessions")
+
+// tokenIsCurrent rejects tokens issued before the password of the user was reset
+// or changed, and login tokens whose session was revoked. Valid results are cached.
+func tokenIsCurrent(c *gin.Context, claims *helpers.SignedDetails) bool {
+	key := claims.UserID + "|" + claims.SessionID + "|" + strconv.Itoa(claims.TokenVersion)
+	if sessions.valid(key) {
+		return true
+	}
+
+	// refusals are not cached, a transient database error must not lock the session out
+	if !checkToken(c, claims) {
+		return false
+	}
+	sessions.set(key, claims.UserID, claims.SessionID)
+	return true
+}
+
+func checkToken(c *gin.Context, claims *helpers.SignedDetails) bool {
+	ctx := context.Background()
+
+	var user struct {
+		TokenVersion int `bson:"token_version"`
+	}
+	opts := options.FindOne().SetProjection(bson.M{"token_version": 1})
+	err := userCollection.FindOne(ctx, bson.M{"_id": claims.UserID}, opts).Decode(&user)
+	if err != nil || claims.TokenVersion != user.TokenVersion {
+		return false
+	}
+
+	// two-factor step tokens have no session
+	if claims.Scope != "" {
+		return true
+	}
+	if claims.SessionID == "" {
+		return false
+	}
+
+	// last seen is refreshed on cache misses only
+	filter := bson.M{
+		"_id":        claims.SessionID,
+		"user_id":    claims.UserID,
+		"revoked_at": bson.M{"$exists": false},
+		"expires_at": bson.M{"$gt": time.Now()},
+	}
+	update := bson.M{"$set": bson.M{"last_seen_at": time.Now(), "ip": c.ClientIP()}}
+	result, err := sessionCollection.UpdateOne(ctx, filter, update)
+	return err == nil && result.MatchedCount == 1
+}
+
+// authenticate decodes the bearer token of the request and stores its claims as userLogin.
+// Tokens limited to a scope, like the two-factor steps, are only accepted for that scope.
+func authenticate(c *gin.Context, scopes ...string) (*helpers.SignedDetails, bool) {
+	parts := strings.SplitN(c.GetHeader("Authorization"), " ", 2)
+	if len(parts) != 2 {
+		return nil, false
+	}
+
+	claims, err := helpers.DecodeToken(parts[1])
+	if err != nil || !tokenIsCurrent(c, claims) {
+		return nil, false
+	}
+
+	if claims.Scope != "" && !containsScope(scopes, claims.Scope) {
+		return nil, false
+	}
+
+	// Set user information in the context
+	c.Set("userLogin", claims)
+	return claims, true
+}
+
+func containsScope(scopes []string, scope string) bool {
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
 }
 
-// auth function
-func EnsureAdmin() gin.HandlerFunc {
+// EnsureAdmin accepts admin tokens, and API keys granted all the given scopes.
+// Without scopes API keys are refused.
+func EnsureAdmin(apiKeyScopes ...string) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		token := c.GetHeader("Authorization")
-
-		if token == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{
-				"status":  http.StatusUnauthorized,
-				"message": "Unauthorized",
-			})
-			c.Abort()
+		if requestApiKey(c) != "" {
+			if !authenticateApiKey(c, apiKeyScopes) {
+				unauthorized(c)
+				return
+			}
+
+			c.Next()
 			return
 		}
 
-		token = strings.Split(token, " ")[1]
-		claims, err := helpers.DecodeToken(token)
+		claims, ok := authenticate(c)
+		if !ok {
+			unauthorized(c)
+			return
+		}
 
-		roleType, ok := claims["roleType"].(string)
-		if !ok || roleType != "admin" {
-			c.JSON(http.StatusUnauthorized, gin.H{"message": "Unauthorized"})
-			c.Abort()
+		if claims.RoleType != "admin" {
+			responses.Abort(c, responses.Forbidden, "Forbidden")
 			return
 		}
 
-		// Set user information in the context
-		c.Set("userLogin", claims)
+		c.Set("isAdmin", true)
 
-		// Optionally set additional flags based on the roleType
-		if roleType == "admin" {
-			c.Set("isAdmin", true)
+		c.Next()
+	}
+}
+
+func EnsureCustomer() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims, ok := authenticate(c)
+		if !ok {
+			unauthorized(c)
+			return
 		}
 
-		c.Next()
-		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{
-				"status":  http.StatusUnauthorized,
-				"message": "Unauthorized",
-			})
-			c.Abort()
+		if claims.RoleType != "customer" {
+			responses.Abort(c, responses.Forbidden, "Forbidden")
 			return
 		}
 
@@ -60,44 +150,54 @@ func EnsureAdmin() gin.HandlerFunc {
 	}
 }
 
-func EnsureCustomer() gin.HandlerFunc {
+// EnsureAuthenticated accepts any valid token, whatever the role
+func EnsureAuthenticated() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		token := c.GetHeader("Authorization")
-
-		if token == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{
-				"status":  http.StatusUnauthorized,
-				"message": "Unauthorized",
-			})
-			c.Abort()
+		if _, ok := authenticate(c); !ok {
+			unauthorized(c)
 			return
 		}
 
-		token = strings.Split(token, " ")[1]
-		claims, err := helpers.DecodeToken(token)
+		c.Next()
+	}
+}
+
+// OptionalAuthentication stores the claims of a valid login token, requests
+// without one continue anonymously
+func OptionalAuthentication() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		authenticate(c)
+		c.Next()
+	}
+}
 
-		roleType, ok := claims["roleType"].(string)
-		if !ok || roleType != "customer" {
-			c.JSON(http.StatusUnauthorized, gin.H{"message": "Unauthorized"})
-			c.Abort()
+// EnsureSelfOrAdmin accepts admin tokens, and the tokens of the user named by
+// the :id path parameter
+func EnsureSelfOrAdmin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims, ok := authenticate(c)
+		if !ok {
+			unauthorized(c)
 			return
 		}
 
-		// Set user information in the context
-		c.Set("userLogin", claims)
-
-		// Optionally set additional flags based on the roleType
-		if roleType == "customer" {
+		if claims.RoleType == "admin" {
 			c.Set("isAdmin", true)
+		} else if claims.UserID != c.Param("id") {
+			responses.Abort(c, responses.Forbidden, "Forbidden")
+			return
 		}
 
 		c.Next()
-		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{
-				"status":  http.StatusUnauthorized,
-				"message": "Unauthorized",
-			})
-			c.Abort()
+	}
+}
+
+// EnsureMfaEnrollment accepts regular tokens and the tokens issued to set up
+// two-factor authentication when the role requires it
+func EnsureMfaEnrollment() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, ok := authenticate(c, helpers.ScopeMfaEnroll); !ok {
+			unauthorized(c)
 			return
 		}
 
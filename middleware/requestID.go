@@ -0,0 +1,29 @@
+package middleware
+
+import (
+	"regexp"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// RequestIDHeader carries the id of a request, it is echoed in the response
+const RequestIDHeader = "X-Request-ID"
+
+// ids sent by a proxy are kept when they are short and printable
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// RequestID keeps the X-Request-ID of the request or generates one, so logs
+// and audit entries can be matched with the response a client got
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(RequestIDHeader)
+		if !requestIDPattern.MatchString(id) {
+			id = uuid.New().String()
+		}
+
+		c.Set("requestID", id)
+		c.Header(RequestIDHeader, id)
+		c.Next()
+	}
+}
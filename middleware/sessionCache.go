@@ -0,0 +1,92 @@
+package middleware
+
+import (
+	"os"
+	"sync"
+	"time"
+)
+
+// valid session checks are kept this long, so a session revoked through
+// another instance can still be used for up to this duration. AUTH_CACHE_TTL overrides it.
+const defaultSessionCacheTTL = 30 * time.Second
+
+const sessionCacheSize = 10000
+
+type sessionCacheEntry struct {
+	userID    string
+	sessionID string
+	expiresAt time.Time
+}
+
+// sessionCache remembers the tokens whose session and token version were
+// valid. Refused tokens are not kept, a failed check is retried on the next request.
+type sessionCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]sessionCacheEntry
+}
+
+var sessions = newSessionCache()
+
+func newSessionCache() *sessionCache {
+	ttl, err := time.ParseDuration(os.Getenv("AUTH_CACHE_TTL"))
+	if err != nil || ttl < 0 {
+		ttl = defaultSessionCacheTTL
+	}
+	return &sessionCache{ttl: ttl, entries: map[string]sessionCacheEntry{}}
+}
+
+// valid tells whether the token of key was found valid within the ttl
+func (s *sessionCache) valid(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.entries[key]
+	return ok && time.Now().Before(entry.expiresAt)
+}
+
+func (s *sessionCache) set(key, userID, sessionID string) {
+	if s.ttl == 0 {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.entries) >= sessionCacheSize {
+		now := time.Now()
+		for k, entry := range s.entries {
+			if now.After(entry.expiresAt) {
+				delete(s.entries, k)
+			}
+		}
+		// still full of live entries, start over rather than grow
+		if len(s.entries) >= sessionCacheSize {
+			s.entries = map[string]sessionCacheEntry{}
+		}
+	}
+	s.entries[key] = sessionCacheEntry{userID: userID, sessionID: sessionID, expiresAt: time.Now().Add(s.ttl)}
+}
+
+// forget drops the entries matching, so their tokens are checked again
+func (s *sessionCache) forget(match func(sessionCacheEntry) bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for k, entry := range s.entries {
+		if match(entry) {
+			delete(s.entries, k)
+		}
+	}
+}
+
+// ForgetSession makes this instance check the tokens of a revoked session
+// again. Other instances refuse them once their entries expire.
+func ForgetSession(sessionID string) {
+	sessions.forget(func(entry sessionCacheEntry) bool { return entry.sessionID == sessionID })
+}
+
+// ForgetUser makes this instance check every token of the user again
+func ForgetUser(userID string) {
+	sessions.forget(func(entry sessionCacheEntry) bool { return entry.userID == userID })
+}
@@ -0,0 +1,35 @@
+package middleware
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSessionCacheForget(t *testing.T) {
+	cache := &sessionCache{ttl: time.Minute, entries: map[string]sessionCacheEntry{}}
+	cache.set("user-1|session-1|0", "user-1", "session-1")
+	cache.set("user-1|session-2|0", "user-1", "session-2")
+	cache.set("user-2|session-3|0", "user-2", "session-3")
+
+	previous := sessions
+	sessions = cache
+	t.Cleanup(func() { sessions = previous })
+
+	ForgetSession("session-1")
+	if cache.valid("user-1|session-1|0") || !cache.valid("user-1|session-2|0") {
+		t.Fatal("ForgetSession dropped the wrong entries")
+	}
+
+	ForgetUser("user-1")
+	if cache.valid("user-1|session-2|0") || !cache.valid("user-2|session-3|0") {
+		t.Fatal("ForgetUser dropped the wrong entries")
+	}
+}
+
+func TestSessionCacheExpires(t *testing.T) {
+	cache := &sessionCache{ttl: time.Minute, entries: map[string]sessionCacheEntry{}}
+	cache.entries["key"] = sessionCacheEntry{expiresAt: time.Now().Add(-time.Second)}
+	if cache.valid("key") {
+		t.Fatal("an expired entry is still valid")
+	}
+}
@@ -0,0 +1,37 @@
+package models
+
+import "time"
+
+// permissions an API key can be given
+const (
+	ScopeProductsRead    = "products:read"
+	ScopeProductsWrite   = "products:write"
+	ScopeCategoriesRead  = "categories:read"
+	ScopeCategoriesWrite = "categories:write"
+)
+
+var ApiKeyScopes = []string{ScopeProductsRead, ScopeProductsWrite, ScopeCategoriesRead, ScopeCategoriesWrite}
+
+// ApiKey lets an integration call the API without a user login. Only the
+// hash of the key is stored, the prefix identifies it in lists and logs.
+type ApiKey struct {
+	ID           string     `json:"id" bson:"_id"`
+	Name         string     `json:"name" bson:"name"`
+	Prefix       string     `json:"prefix" bson:"prefix"`
+	Hash         string     `json:"-" bson:"hash"`
+	Scopes       []string   `json:"scopes" bson:"scopes"`
+	Allowed_ips  []string   `json:"allowed_ips,omitempty" bson:"allowed_ips,omitempty"`
+	Expires_at   *time.Time `json:"expires_at,omitempty" bson:"expires_at,omitempty"`
+	Last_used_at *time.Time `json:"last_used_at,omitempty" bson:"last_used_at,omitempty"`
+	Last_used_ip string     `json:"last_used_ip,omitempty" bson:"last_used_ip,omitempty"`
+	Revoked_at   *time.Time `json:"revoked_at,omitempty" bson:"revoked_at,omitempty"`
+	Created_by   string     `json:"created_by" bson:"created_by"`
+	Created_at   time.Time  `json:"created_at" bson:"created_at"`
+}
+
+type CreateApiKeyRequest struct {
+	Name        string     `json:"name" binding:"required,max=100"`
+	Scopes      []string   `json:"scopes" binding:"required,min=1,dive,oneof=products:read products:write categories:read categories:write"`
+	Allowed_ips []string   `json:"allowed_ips" binding:"omitempty,max=50,dive,ip|cidr"`
+	Expires_at  *time.Time `json:"expires_at"`
+}
@@ -0,0 +1,49 @@
+package models
+
+import "time"
+
+// kinds of audited changes
+const (
+	AuditCreate  = "create"
+	AuditUpdate  = "update"
+	AuditDelete  = "delete"
+	AuditRestore = "restore"
+	AuditPurge   = "purge"
+)
+
+// AuditEntry records one change of a product, categori, user or role. Entries
+// are only ever inserted.
+type AuditEntry struct {
+	ID          string                 `json:"id" bson:"_id"`
+	Actor_id    string                 `json:"actor_id" bson:"actor_id"` // user id, "api_key:<id>" or "system"
+	Actor_role  string                 `json:"actor_role,omitempty" bson:"actor_role,omitempty"`
+	Action      string                 `json:"action" bson:"action"`
+	Entity_type string                 `json:"entity_type" bson:"entity_type"`
+	Entity_id   string                 `json:"entity_id" bson:"entity_id"`
+	Changes     map[string]AuditChange `json:"changes,omitempty" bson:"changes,omitempty"` // by stored field name
+	Request_id  string                 `json:"request_id,omitempty" bson:"request_id,omitempty"`
+	IP          string                 `json:"ip,omitempty" bson:"ip,omitempty"`
+	Created_at  time.Time              `json:"created_at" bson:"created_at"`
+}
+
+// AuditChange is the value of a field before and after a change, nil when absent
+type AuditChange struct {
+	Before interface{} `json:"before" bson:"before"`
+	After  interface{} `json:"after" bson:"after"`
+}
+
+// AuditFilter filters the audit log, the export takes it alone
+type AuditFilter struct {
+	Entity_type string    `form:"entity_type" binding:"omitempty,oneof=product categori user role"`
+	Entity_id   string    `form:"entity_id" binding:"omitempty,max=100"`
+	Actor_id    string    `form:"actor_id" binding:"omitempty,max=100"`
+	Action      string    `form:"action" binding:"omitempty,oneof=create update delete restore purge"`
+	From        time.Time `form:"from"`
+	To          time.Time `form:"to"`
+}
+
+// AuditQuery filters a page of the audit log
+type AuditQuery struct {
+	AuditFilter
+	Limit int64 `form:"limit" binding:"min=1,max=1000"`
+}
@@ -0,0 +1,27 @@
+package models
+
+// bulk operations on products
+const (
+	BulkAdjustPrice = "adjust_price"
+	BulkSetStock    = "set_stock"
+	BulkSetCategori = "set_categori"
+	BulkDelete      = "delete"
+)
+
+// how a bulk operation handles failed products
+const (
+	BulkAtomic  = "atomic"  // one transaction, a single failure changes nothing
+	BulkChunked = "chunked" // independent writes by chunk, failures are skipped
+)
+
+// ProductBulkRequest applies one operation to the products listed by ids or
+// matched by filter, at most 1000 of them
+type ProductBulkRequest struct {
+	IDs         []string      `json:"ids" binding:"required_without=Filter,max=1000,dive,uuid"`
+	Filter      *ProductQuery `json:"filter"`
+	Operation   string        `json:"operation" binding:"required,oneof=adjust_price set_stock set_categori delete"`
+	Percent     float64       `json:"percent" binding:"required_if=Operation adjust_price,gt=-100,lte=1000"` // +10 raises prices by 10%
+	Stock       string        `json:"stock" binding:"required_if=Operation set_stock,omitempty,number,max=9"`
+	Categori_id string        `json:"categori_id" binding:"required_if=Operation set_categori,omitempty,uuid"`
+	Mode        string        `json:"mode" binding:"omitempty,oneof=atomic chunked"` // chunked when empty
+}
@@ -8,16 +8,22 @@ import (
 type Categori struct {
 	ID        string                `json:"id,omitempty" bson:"_id,omitempty"`
 	Name      string                `json:"name,omitempty" bson:"name,omitempty"`
+	Slug      string                `json:"slug,omitempty" bson:"slug,omitempty"`
 	Image     *multipart.FileHeader `json:"image,omitempty" bson:"image,omitempty"`
+	Version   int64                 `json:"version" bson:"version,omitempty"` // sent as the ETag
 	CreatedAt time.Time             `json:"created_at"`
 	UpdatedAt time.Time             `json:"updated_at"`
+	DeletedAt *time.Time            `json:"deleted_at,omitempty" bson:"deleted_at,omitempty"` // in the trash until purged
+	DeletedBy string                `json:"deleted_by,omitempty" bson:"deleted_by,omitempty"`
 }
 
 // required to form data
 type CreateCategoriRequest struct {
 	ID        string                `json:"id,omitempty" bson:"_id,omitempty"`
-	Name      string                `form:"name" binding:"required"`
+	Name      string                `form:"name" binding:"required,min=2,max=100"`
+	Slug      string                `form:"slug" bson:"slug,omitempty" binding:"omitempty,slug,max=100"`
 	Image     *multipart.FileHeader `form:"image" binding:"required"`
+	Version   int64                 `json:"version" bson:"version" form:"-"`
 	CreatedAt time.Time             `json:"created_at"`
 	UpdatedAt time.Time             `json:"updated_at"`
 }
@@ -25,16 +31,24 @@ type CreateCategoriRequest struct {
 // required to update image form data
 type UpdateCategoriRequest struct {
 	ID        string                `form:"id,omitempty" bson:"_id,omitempty"`
-	Name      string                `form:"name"`
-	Image     *multipart.FileHeader `form:"image" binding:"-"`
-	CreatedAt time.Time             `json:"created_at"`
+	Name      string                `form:"name" binding:"omitempty,min=2,max=100"`
+	Slug      string                `form:"slug" bson:"slug,omitempty" binding:"omitempty,slug,max=100"`
+	Image     *multipart.FileHeader `form:"image" bson:"image,omitempty" binding:"-"`
+	CreatedAt time.Time             `json:"created_at" bson:"-"`
 	UpdatedAt time.Time             `json:"updated_at"`
 }
 
+// CategoriPatch lists the fields a PATCH may change, nil fields are left untouched
+type CategoriPatch struct {
+	Name *string `json:"name" bson:"name" binding:"omitempty,min=2,max=100"`
+	Slug *string `json:"slug" bson:"slug" binding:"omitempty,slug,max=100"`
+}
+
 // update not required to upload image form data
 type UpdateCategoriRequestNoImage struct {
 	ID        string                `json:"id,omitempty" bson:"_id,omitempty"`
-	Name      string                `form:"name" json:"name,omitempty" bson:"name,omitempty"`
+	Name      string                `form:"name" json:"name,omitempty" bson:"name,omitempty" binding:"omitempty,min=2,max=100"`
+	Slug      string                `form:"slug" json:"slug,omitempty" bson:"slug,omitempty" binding:"omitempty,slug,max=100"`
 	Image     *multipart.FileHeader `json:"image,omitempty" bson:"-"` // Remove "form" and "binding" tags
 	CreatedAt time.Time             `json:"created_at"`
 	UpdatedAt time.Time             `json:"updated_at"`
@@ -0,0 +1,24 @@
+package models
+
+import "time"
+
+// Identity links the subject of an external identity provider to a user
+type Identity struct {
+	ID         string    `json:"-" bson:"_id"` // provider|subject
+	User_id    string    `json:"user_id" bson:"user_id"`
+	Provider   string    `json:"provider" bson:"provider"`
+	Subject    string    `json:"subject" bson:"subject"`
+	Email      string    `json:"email,omitempty" bson:"email,omitempty"`
+	Created_at time.Time `json:"created_at" bson:"created_at"`
+}
+
+// OidcState remembers an authorization request until the provider redirects back,
+// stored by the hash of the state parameter
+type OidcState struct {
+	ID            string    `bson:"_id"`
+	Provider      string    `bson:"provider"`
+	Code_verifier string    `bson:"code_verifier"`
+	Nonce         string    `bson:"nonce"`
+	Link_user_id  string    `bson:"link_user_id,omitempty"` // set when linking to a logged in user
+	Expires_at    time.Time `bson:"expires_at"`
+}
@@ -0,0 +1,62 @@
+package models
+
+import "time"
+
+// states of a product import
+const (
+	ImportPending   = "pending"
+	ImportRunning   = "running"
+	ImportCompleted = "completed"
+	ImportFailed    = "failed"
+)
+
+// ProductImportRequest is the multipart form of a product import, the rows
+// are sent as the file field and the images they name as the images zip
+type ProductImportRequest struct {
+	Format string `form:"format" binding:"omitempty,oneof=csv jsonl"` // taken from the file extension when empty
+	DryRun bool   `form:"dry_run"`
+}
+
+// ProductImportRow is one product of an import, a CSV column or JSON key per
+// field. Rows are upserted by sku, empty optional fields keep the stored value
+// of an existing product. image is a file of the images zip or the object key
+// of an image already stored below catalog/.
+type ProductImportRow struct {
+	Sku      string `json:"sku" binding:"required,sku"`
+	Name     string `json:"name" binding:"required,min=2,max=100"`
+	Slug     string `json:"slug" binding:"omitempty,slug,max=100"`
+	Image    string `json:"image" binding:"max=200"`
+	Price    string `json:"price" binding:"required,amount"`
+	Currency string `json:"currency" binding:"omitempty,currency"`
+	Desc     string `json:"desc" binding:"max=2000"`
+	Stock    string `json:"stock" binding:"omitempty,number,max=9"`
+	Weight   string `json:"weight" binding:"omitempty,numeric,max=12"`
+}
+
+// ProductImport is the progress of a product import job
+type ProductImport struct {
+	ID          string     `json:"id" bson:"_id"`
+	Status      string     `json:"status" bson:"status"`
+	Format      string     `json:"format" bson:"format"`
+	Dry_run     bool       `json:"dry_run" bson:"dry_run"` // rows are checked but nothing is written
+	Total       int        `json:"total" bson:"total"`
+	Processed   int        `json:"processed" bson:"processed"`
+	Created     int        `json:"created" bson:"created"`
+	Updated     int        `json:"updated" bson:"updated"`
+	Failed      int        `json:"failed" bson:"failed"`
+	Error       string     `json:"error,omitempty" bson:"error,omitempty"` // why the whole job failed
+	Created_by  string     `json:"created_by,omitempty" bson:"created_by,omitempty"`
+	Created_at  time.Time  `json:"created_at" bson:"created_at"`
+	Finished_at *time.Time `json:"finished_at,omitempty" bson:"finished_at,omitempty"`
+}
+
+// ProductImportError is a refused field of an import row, listed by the report
+type ProductImportError struct {
+	ID        string `json:"-" bson:"_id"`
+	Import_id string `json:"-" bson:"import_id"`
+	Row       int    `json:"row" bson:"row"` // line of the file, the CSV header is line 1
+	Sku       string `json:"sku" bson:"sku"`
+	Field     string `json:"field" bson:"field"`
+	Rule      string `json:"rule" bson:"rule"`
+	Message   string `json:"message" bson:"message"`
+}
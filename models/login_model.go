@@ -0,0 +1,30 @@
+package models
+
+import "time"
+
+// LoginAttempt counts the recent failed logins of an account or an IP
+type LoginAttempt struct {
+	ID              string    `json:"id" bson:"_id"`
+	Failures        int       `json:"failures" bson:"failures"`
+	Last_failure_at time.Time `json:"last_failure_at" bson:"last_failure_at"`
+	Next_allowed_at time.Time `json:"next_allowed_at" bson:"next_allowed_at"`
+	Locked_until    time.Time `json:"locked_until" bson:"locked_until"`
+}
+
+// LoginEvent records a failed or blocked login
+type LoginEvent struct {
+	ID         string    `json:"id" bson:"_id"`
+	Username   string    `json:"username" bson:"username"`
+	User_id    string    `json:"user_id,omitempty" bson:"user_id,omitempty"`
+	IP         string    `json:"ip" bson:"ip"`
+	User_agent string    `json:"user_agent" bson:"user_agent"`
+	Reason     string    `json:"reason" bson:"reason"`
+	Created_at time.Time `json:"created_at" bson:"created_at"`
+}
+
+// LoginEventsQuery filters the login events list
+type LoginEventsQuery struct {
+	Username string `form:"username" binding:"max=50"`
+	IP       string `form:"ip" binding:"omitempty,ip"`
+	Limit    int64  `form:"limit" binding:"min=1,max=1000"`
+}
@@ -6,37 +6,65 @@ import (
 )
 
 type Product struct {
-	ID         string                `json:"id,omitempty" bson:"_id,omitempty"`
-	Name       string                `json:"name,omitempty" bson:"name,omitempty"`
-	Image      *multipart.FileHeader `json:"image,omitempty" bson:"image,omitempty"`
-	Price      string                `json:"price,omitempty" bson:"price,omitempty"`
-	Desc       string                `json:"desc,omitempty" bson:"desc,omitempty"`
-	Stock      string                `json:"stock,omitempty" bson:"stock,omitempty"`
-	Weight     string                `json:"weight,omitempty" bson:"weight,omitempty"`
-	Created_at time.Time             `json:"created_at"`
-	Updated_at time.Time             `json:"updated_at"`
+	ID          string                `json:"id,omitempty" bson:"_id,omitempty"`
+	Name        string                `json:"name,omitempty" bson:"name,omitempty"`
+	Sku         string                `json:"sku,omitempty" bson:"sku,omitempty"` // unique, imports upsert by it
+	Slug        string                `json:"slug,omitempty" bson:"slug,omitempty"`
+	Image       *multipart.FileHeader `json:"image,omitempty" bson:"image,omitempty"`
+	Price       string                `json:"price,omitempty" bson:"price,omitempty"`
+	Currency    string                `json:"currency,omitempty" bson:"currency,omitempty"`
+	Desc        string                `json:"desc,omitempty" bson:"desc,omitempty"`
+	Stock       string                `json:"stock,omitempty" bson:"stock,omitempty"`
+	Weight      string                `json:"weight,omitempty" bson:"weight,omitempty"`
+	Categori_id string                `json:"categori_id,omitempty" bson:"categori_id,omitempty"`
+	Version     int64                 `json:"version" bson:"version,omitempty"` // sent as the ETag
+	Created_at  time.Time             `json:"created_at" bson:"createdat"`
+	Updated_at  time.Time             `json:"updated_at" bson:"updatedat"`
+	Deleted_at  *time.Time            `json:"deleted_at,omitempty" bson:"deleted_at,omitempty"` // in the trash until purged
+	Deleted_by  string                `json:"deleted_by,omitempty" bson:"deleted_by,omitempty"`
 }
 
 type CreateProductRequest struct {
-	ID        string                `json:"id,omitempty" bson:"_id,omitempty"`
-	Name      string                `form:"name" binding:"required"`
-	Image     *multipart.FileHeader `form:"image" binding:"required"`
-	Price     string                `form:"price" binding:"required"`
-	Desc      string                `form:"desc"`
-	Stock     string                `form:"stock"`
-	Weight    string                `form:"weight"`
-	CreatedAt time.Time             `json:"created_at"`
-	UpdatedAt time.Time             `json:"updated_at"`
+	ID         string                `json:"id,omitempty" bson:"_id,omitempty"`
+	Name       string                `form:"name" binding:"required,min=2,max=100"`
+	Sku        string                `form:"sku" bson:"sku,omitempty" binding:"omitempty,sku"`
+	Slug       string                `form:"slug" bson:"slug,omitempty" binding:"omitempty,slug,max=100"`
+	Image      *multipart.FileHeader `form:"image" binding:"required"`
+	Price      string                `form:"price" binding:"required,amount"`
+	Currency   string                `form:"currency" bson:"currency,omitempty" binding:"omitempty,currency"`
+	Desc       string                `form:"desc" binding:"max=2000"`
+	Stock      string                `form:"stock" binding:"omitempty,number,max=9"`
+	Weight     string                `form:"weight" binding:"omitempty,numeric,max=12"`
+	CategoriID string                `form:"categori_id" bson:"categori_id,omitempty" binding:"omitempty,uuid"`
+	Version    int64                 `json:"version" bson:"version" form:"-"`
+	CreatedAt  time.Time             `json:"created_at"`
+	UpdatedAt  time.Time             `json:"updated_at"`
 }
 
 type UpdateProductRequest struct {
 	ID        string                `json:"id,omitempty" bson:"_id,omitempty"`
-	Name      string                `form:"name" binding:"required"`
-	Image     *multipart.FileHeader `form:"image" binding:"-"`
-	Price     string                `form:"price" binding:"required"`
-	Desc      string                `form:"desc"`
-	Stock     string                `form:"stock" binding:"required"`
-	Weight    string                `form:"weight" binding:"required"`
-	CreatedAt time.Time             `json:"created_at"`
+	Name      string                `form:"name" binding:"required,min=2,max=100"`
+	Sku       string                `form:"sku" bson:"sku,omitempty" binding:"omitempty,sku"`
+	Slug      string                `form:"slug" bson:"slug,omitempty" binding:"omitempty,slug,max=100"`
+	Image     *multipart.FileHeader `form:"image" bson:"image,omitempty" binding:"-"`
+	Price     string                `form:"price" binding:"required,amount"`
+	Currency  string                `form:"currency" bson:"currency,omitempty" binding:"omitempty,currency"`
+	Desc      string                `form:"desc" binding:"max=2000"`
+	Stock     string                `form:"stock" binding:"required,number,max=9"`
+	Weight    string                `form:"weight" binding:"required,numeric,max=12"`
+	CreatedAt time.Time             `json:"created_at" bson:"-"`
 	UpdatedAt time.Time             `json:"updated_at"`
 }
+
+// ProductPatch lists the fields a PATCH may change, nil fields are left untouched
+type ProductPatch struct {
+	Name        *string `json:"name" bson:"name" binding:"omitempty,min=2,max=100"`
+	Sku         *string `json:"sku" bson:"sku" binding:"omitempty,sku"`
+	Slug        *string `json:"slug" bson:"slug" binding:"omitempty,slug,max=100"`
+	Price       *string `json:"price" bson:"price" binding:"omitempty,amount"`
+	Currency    *string `json:"currency" bson:"currency" binding:"omitempty,currency"`
+	Desc        *string `json:"desc" bson:"desc" binding:"omitempty,max=2000"`
+	Stock       *string `json:"stock" bson:"stock" binding:"omitempty,number,max=9"`
+	Weight      *string `json:"weight" bson:"weight" binding:"omitempty,numeric,max=12"`
+	Categori_id *string `json:"categori_id" bson:"categori_id" binding:"omitempty,uuid"`
+}
@@ -0,0 +1,27 @@
+package models
+
+import "time"
+
+// IDParam is the :id path parameter of the resource routes
+type IDParam struct {
+	ID string `uri:"id" binding:"required,uuid"`
+}
+
+// ProductQuery filters the product list and export
+type ProductQuery struct {
+	Q             string    `json:"q" form:"q" binding:"max=100"` // part of the name, sku or slug
+	Currency      string    `json:"currency" form:"currency" binding:"omitempty,currency"`
+	Categori_id   string    `json:"categori_id" form:"categori_id" binding:"omitempty,uuid"`
+	Updated_since time.Time `json:"updated_since" form:"updated_since"`
+}
+
+// CategoriQuery filters the categori list and export
+type CategoriQuery struct {
+	Q             string    `form:"q" binding:"max=100"` // part of the name or slug
+	Updated_since time.Time `form:"updated_since"`
+}
+
+// ExportQuery is the file format of an export
+type ExportQuery struct {
+	Format string `form:"format" binding:"required,oneof=csv jsonl xlsx"`
+}
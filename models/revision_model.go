@@ -0,0 +1,27 @@
+package models
+
+import "time"
+
+// ProductRevision is an immutable snapshot of the content of a product,
+// taken whenever a change creates a new product version
+type ProductRevision struct {
+	ID          string                 `json:"id" bson:"_id"`
+	Product_id  string                 `json:"product_id" bson:"product_id"`
+	Version     int64                  `json:"version" bson:"version"` // product version of the snapshot
+	Snapshot    map[string]interface{} `json:"-" bson:"snapshot"`      // stored product fields, image included
+	Author_id   string                 `json:"author_id,omitempty" bson:"author_id,omitempty"`
+	Rollback_of int64                  `json:"rollback_of,omitempty" bson:"rollback_of,omitempty"` // version restored by a rollback
+	Created_at  time.Time              `json:"created_at" bson:"created_at"`
+}
+
+// RevisionParam is the path of a single product revision
+type RevisionParam struct {
+	ID      string `uri:"id" binding:"required,uuid"`
+	Version int64  `uri:"version" binding:"required,min=1"`
+}
+
+// RevisionDiffQuery names the two revisions to compare
+type RevisionDiffQuery struct {
+	From int64 `form:"from" binding:"required,min=1"`
+	To   int64 `form:"to" binding:"required,min=1"`
+}
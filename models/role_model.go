@@ -3,8 +3,13 @@ package models
 import "time"
 
 type Role struct {
-	ID         string    `json:"id,omitempty" bson:"_id,omitempty"`
-	Name       string    `json:"name,omitempty" bson:"name,omitempty"`
-	Created_at time.Time `json:"created_at"`
-	Updated_at time.Time `json:"updated_at"`
+	ID          string    `json:"id,omitempty" bson:"_id,omitempty"`
+	Name        string    `json:"name,omitempty" bson:"name,omitempty" binding:"required,min=2,max=50"`
+	Require_mfa bool      `json:"require_mfa" bson:"require_mfa,omitempty"`
+	Created_at  time.Time `json:"created_at"`
+	Updated_at  time.Time `json:"updated_at"`
+}
+
+type RoleMfaPolicyRequest struct {
+	Require_mfa *bool `json:"require_mfa" binding:"required"`
 }
@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+// Session is created on every login, the token carries its id as the sid claim
+type Session struct {
+	ID           string     `json:"id" bson:"_id"`
+	User_id      string     `json:"user_id" bson:"user_id"`
+	User_agent   string     `json:"user_agent" bson:"user_agent"`
+	IP           string     `json:"ip" bson:"ip"`
+	Created_at   time.Time  `json:"created_at" bson:"created_at"`
+	Last_seen_at time.Time  `json:"last_seen_at" bson:"last_seen_at"`
+	Expires_at   time.Time  `json:"expires_at" bson:"expires_at"`
+	Revoked_at   *time.Time `json:"revoked_at,omitempty" bson:"revoked_at,omitempty"`
+	Current      bool       `json:"current,omitempty" bson:"-"` // session of the request
+}
@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// purposes of a UserToken
+const (
+	TokenVerifyEmail   = "verify_email"
+	TokenResetPassword = "reset_password"
+)
+
+// UserToken is a single use token sent by email, stored by its hash
+type UserToken struct {
+	ID         string    `json:"id,omitempty" bson:"_id,omitempty"`
+	User_id    string    `json:"user_id" bson:"user_id"`
+	Purpose    string    `json:"purpose" bson:"purpose"`
+	Expires_at time.Time `json:"expires_at" bson:"expires_at"`
+	Created_at time.Time `json:"created_at" bson:"created_at"`
+}
@@ -0,0 +1,22 @@
+package models
+
+import "time"
+
+type PresignUploadRequest struct {
+	ContentType string `json:"content_type" binding:"required,oneof=image/png image/jpeg"`
+	Size        int64  `json:"size" binding:"required,gt=0"`
+}
+
+type PresignUploadResponse struct {
+	Key       string            `json:"key"`
+	URL       string            `json:"url"`
+	FormData  map[string]string `json:"form_data"`
+	ExpiresAt time.Time         `json:"expires_at"`
+}
+
+// attaches an uploaded object to a product or categori
+type FinalizeUploadRequest struct {
+	Key    string `json:"key" binding:"required,max=200"`
+	Target string `json:"target" binding:"required,oneof=product categori"`
+	ID     string `json:"id" binding:"required,uuid"`
+}
@@ -7,12 +7,98 @@ import (
 )
 
 type User struct {
-	ID         string                `json:"id,omitempty" bson:"_id,omitempty"`
-	Username   string                `form:"username" binding:"required"`
-	Password   string                `form:"password" binding:"required"`
-	Name       string                `form:"name"`
-	Image      *multipart.FileHeader `form:"image" binding:"-"`
-	Role_id    string                `form:"role_id"`
-	Created_at time.Time             `json:"created_at"`
-	Updated_at time.Time             `json:"updated_at"`
+	ID                  string                `json:"id,omitempty" bson:"_id,omitempty"`
+	Username            string                `form:"username" binding:"required,min=3,max=50"`
+	Password            string                `json:"-" form:"password" binding:"required,max=72"`
+	Name                string                `form:"name" binding:"max=100"`
+	Email               string                `form:"email" bson:"email,omitempty" binding:"omitempty,email,max=254"`
+	Image               *multipart.FileHeader `form:"image" bson:"image,omitempty" binding:"-"`
+	Role_id             string                `form:"role_id" binding:"omitempty,uuid"`
+	AvatarURL           string                `json:"avatar_url,omitempty" bson:"-" form:"-"`
+	PendingVerification bool                  `json:"pending_verification,omitempty" bson:"pending_verification,omitempty" form:"-"` // until the email is verified
+	Verified_at         *time.Time            `json:"verified_at,omitempty" bson:"verified_at,omitempty" form:"-"`
+	Token_version       int                   `json:"-" bson:"token_version,omitempty" form:"-"` // bumped to revoke every issued token
+	Mfa_enabled         bool                  `json:"mfa_enabled,omitempty" bson:"mfa_enabled,omitempty" form:"-"`
+	Mfa_secret          string                `json:"-" bson:"mfa_secret,omitempty" form:"-"`
+	Mfa_pending_secret  string                `json:"-" bson:"mfa_pending_secret,omitempty" form:"-"` // until the first code is confirmed
+	Mfa_recovery_codes  []string              `json:"-" bson:"mfa_recovery_codes,omitempty" form:"-"` // hashed
+	Mfa_last_step       int64                 `json:"-" bson:"mfa_last_step,omitempty" form:"-"`      // refuses a code used twice
+	Version             int64                 `json:"version" bson:"version,omitempty" form:"-"`      // sent as the ETag, bumped by every edit
+	Created_at          time.Time             `json:"created_at" bson:"created_at,omitempty"`
+	Updated_at          time.Time             `json:"updated_at"`
+}
+
+// CreateUserRequest is a user created by an admin, with any role
+type CreateUserRequest struct {
+	Username string                `form:"username" json:"username" binding:"required,min=3,max=50"`
+	Password string                `form:"password" json:"password" binding:"required,max=72"`
+	Name     string                `form:"name" json:"name" binding:"max=100"`
+	Email    string                `form:"email" json:"email" binding:"omitempty,email,max=254"`
+	Image    *multipart.FileHeader `form:"image" json:"-" binding:"-"`
+	Role_id  string                `form:"role_id" json:"role_id" binding:"omitempty,uuid"`
+}
+
+// UserUpdate replaces the profile of a user. The role only changes through a
+// PATCH and the password through the password endpoints.
+type UserUpdate struct {
+	Username string `form:"username" json:"username" bson:"username" binding:"required,min=3,max=50"`
+	Name     string `form:"name" json:"name" bson:"name" binding:"max=100"`
+	Email    string `form:"email" json:"email" bson:"email,omitempty" binding:"omitempty,email,max=254"`
+}
+
+// UserPatch lists the fields a PATCH may change, nil fields are left untouched.
+// Passwords only change through the password endpoints.
+type UserPatch struct {
+	Username *string `json:"username" bson:"username" binding:"omitempty,min=3,max=50"`
+	Name     *string `json:"name" bson:"name" binding:"omitempty,max=100"`
+	Email    *string `json:"email" bson:"email" binding:"omitempty,email,max=254"`
+	Role_id  *string `json:"role_id" bson:"role_id" binding:"omitempty,uuid"`
+}
+
+// self registration, the role is always the default one
+type RegisterRequest struct {
+	Username string                `form:"username" json:"username" binding:"required,min=3,max=50"`
+	Email    string                `form:"email" json:"email" binding:"required,email,max=254"`
+	Password string                `form:"password" json:"password" binding:"required,min=8,max=72"`
+	Name     string                `form:"name" json:"name" binding:"max=100"`
+	Image    *multipart.FileHeader `form:"image" json:"-" binding:"-"`
+}
+
+// a new avatar of the logged in user
+type AvatarRequest struct {
+	Image *multipart.FileHeader `form:"image" binding:"required"`
+}
+
+type ResendVerificationRequest struct {
+	Email string `form:"email" json:"email" binding:"required,email,max=254"`
+}
+
+type ForgotPasswordRequest struct {
+	Username string `form:"username" json:"username" binding:"required,max=50"`
+}
+
+type ResetPasswordRequest struct {
+	Token    string `form:"token" json:"token" binding:"required"`
+	Password string `form:"password" json:"password" binding:"required,min=8,max=72"`
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `form:"current_password" json:"current_password" binding:"required"`
+	NewPassword     string `form:"new_password" json:"new_password" binding:"required,min=8,max=72"`
+}
+
+type MfaCodeRequest struct {
+	Code string `form:"code" json:"code" binding:"required,number,len=6"`
+}
+
+// second login step, with either a TOTP code or a recovery code
+type MfaVerifyRequest struct {
+	ChallengeToken string `form:"challenge_token" json:"challenge_token" binding:"required"`
+	Code           string `form:"code" json:"code" binding:"required_without=RecoveryCode,omitempty,number,len=6"`
+	RecoveryCode   string `form:"recovery_code" json:"recovery_code" binding:"omitempty,max=20"`
+}
+
+type MfaDisableRequest struct {
+	Password string `form:"password" json:"password" binding:"required"`
+	Code     string `form:"code" json:"code" binding:"required,number,len=6"`
 }
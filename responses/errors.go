@@ -0,0 +1,81 @@
+package responses
+
+import "net/http"
+
+// ErrorCode is an entry of the error catalog, the code is stable and meant for clients
+type ErrorCode struct {
+	Status int    `json:"status"`
+	Code   string `json:"code"`
+	Title  string `json:"title"`
+}
+
+// generic errors
+var (
+	InvalidRequest   = ErrorCode{http.StatusBadRequest, "INVALID_REQUEST", "The request is invalid"}
+	ValidationFailed = ErrorCode{http.StatusBadRequest, "VALIDATION_FAILED", "Some fields are invalid"}
+	Unauthorized     = ErrorCode{http.StatusUnauthorized, "UNAUTHORIZED", "Authentication is required"}
+	Forbidden        = ErrorCode{http.StatusForbidden, "FORBIDDEN", "Access is denied"}
+	NotFound         = ErrorCode{http.StatusNotFound, "NOT_FOUND", "The resource does not exist"}
+	Conflict         = ErrorCode{http.StatusConflict, "CONFLICT", "The request conflicts with the current state"}
+	TooManyRequests  = ErrorCode{http.StatusTooManyRequests, "TOO_MANY_REQUESTS", "Too many requests"}
+	// the If-Match header names an outdated version, the client must read the resource again
+	PreconditionFailed   = ErrorCode{http.StatusPreconditionFailed, "PRECONDITION_FAILED", "The resource changed since it was read"}
+	PreconditionRequired = ErrorCode{http.StatusPreconditionRequired, "PRECONDITION_REQUIRED", "An If-Match header is required"}
+	InternalError        = ErrorCode{http.StatusInternalServerError, "INTERNAL_ERROR", "An unexpected error occurred"}
+	UpstreamUnavailable  = ErrorCode{http.StatusBadGateway, "UPSTREAM_UNAVAILABLE", "A dependency is unavailable"}
+)
+
+// resources
+var (
+	UserNotFound     = ErrorCode{http.StatusNotFound, "USER_NOT_FOUND", "The user does not exist"}
+	RoleNotFound     = ErrorCode{http.StatusNotFound, "ROLE_NOT_FOUND", "The role does not exist"}
+	ProductNotFound  = ErrorCode{http.StatusNotFound, "PRODUCT_NOT_FOUND", "The product does not exist"}
+	RevisionNotFound = ErrorCode{http.StatusNotFound, "REVISION_NOT_FOUND", "The product revision does not exist"}
+	CategoryNotFound = ErrorCode{http.StatusNotFound, "CATEGORY_NOT_FOUND", "The category does not exist"}
+	ImageNotFound    = ErrorCode{http.StatusNotFound, "IMAGE_NOT_FOUND", "The image does not exist"}
+	UploadNotFound   = ErrorCode{http.StatusNotFound, "UPLOAD_NOT_FOUND", "The upload does not exist"}
+	ImportNotFound   = ErrorCode{http.StatusNotFound, "IMPORT_NOT_FOUND", "The import does not exist"}
+	SessionNotFound  = ErrorCode{http.StatusNotFound, "SESSION_NOT_FOUND", "The session does not exist"}
+	ApiKeyNotFound   = ErrorCode{http.StatusNotFound, "API_KEY_NOT_FOUND", "The API key does not exist"}
+	ProviderNotFound = ErrorCode{http.StatusNotFound, "PROVIDER_NOT_FOUND", "The identity provider is not configured"}
+	IdentityNotFound = ErrorCode{http.StatusNotFound, "IDENTITY_NOT_FOUND", "The identity provider is not linked"}
+	UserExists       = ErrorCode{http.StatusConflict, "USER_EXISTS", "The username or email is already registered"}
+	SkuExists        = ErrorCode{http.StatusConflict, "SKU_EXISTS", "The SKU belongs to another product"}
+	IdentityLinked   = ErrorCode{http.StatusConflict, "IDENTITY_ALREADY_LINKED", "The external account is already linked"}
+)
+
+// authentication
+var (
+	InvalidCredentials    = ErrorCode{http.StatusUnauthorized, "INVALID_CREDENTIALS", "The login or password is incorrect"}
+	AccountLocked         = ErrorCode{http.StatusTooManyRequests, "ACCOUNT_LOCKED", "Too many failed attempts"}
+	EmailNotVerified      = ErrorCode{http.StatusForbidden, "EMAIL_NOT_VERIFIED", "The email address is not verified"}
+	InvalidToken          = ErrorCode{http.StatusBadRequest, "INVALID_TOKEN", "The link or token is invalid or expired"}
+	PasswordPolicy        = ErrorCode{http.StatusBadRequest, "PASSWORD_POLICY", "The password does not follow the password policy"}
+	MfaRequired           = ErrorCode{http.StatusForbidden, "MFA_REQUIRED", "Two-factor authentication is required"}
+	MfaEnrollmentRequired = ErrorCode{http.StatusForbidden, "MFA_ENROLLMENT_REQUIRED", "Two-factor authentication must be set up"}
+	InvalidMfaCode        = ErrorCode{http.StatusBadRequest, "INVALID_MFA_CODE", "The two-factor code is invalid"}
+	MfaAlreadyEnabled     = ErrorCode{http.StatusConflict, "MFA_ALREADY_ENABLED", "Two-factor authentication is already enabled"}
+	MfaNotEnabled         = ErrorCode{http.StatusBadRequest, "MFA_NOT_ENABLED", "Two-factor authentication is not enabled"}
+	InvalidSignature      = ErrorCode{http.StatusForbidden, "INVALID_SIGNATURE", "The signature is invalid or expired"}
+)
+
+// files
+var (
+	InvalidImage       = ErrorCode{http.StatusBadRequest, "INVALID_IMAGE", "The file is not a supported image"}
+	FileTooLarge       = ErrorCode{http.StatusBadRequest, "FILE_TOO_LARGE", "The file is too large"}
+	InvalidObjectName  = ErrorCode{http.StatusBadRequest, "INVALID_OBJECT_NAME", "The object name is invalid"}
+	UnsupportedContent = ErrorCode{http.StatusBadRequest, "UNSUPPORTED_CONTENT_TYPE", "The content type is not supported"}
+)
+
+// Catalog lists every error code, it is served at /api/errors
+var Catalog = []ErrorCode{
+	InvalidRequest, ValidationFailed, Unauthorized, Forbidden, NotFound, Conflict,
+	TooManyRequests, PreconditionFailed, PreconditionRequired, InternalError, UpstreamUnavailable,
+	UserNotFound, RoleNotFound, ProductNotFound, RevisionNotFound, CategoryNotFound, ImageNotFound,
+	UploadNotFound, ImportNotFound, SessionNotFound, ApiKeyNotFound, ProviderNotFound, IdentityNotFound,
+	UserExists, SkuExists, IdentityLinked,
+	InvalidCredentials, AccountLocked, EmailNotVerified, InvalidToken, PasswordPolicy,
+	MfaRequired, MfaEnrollmentRequired, InvalidMfaCode, MfaAlreadyEnabled, MfaNotEnabled,
+	InvalidSignature,
+	InvalidImage, FileTooLarge, InvalidObjectName, UnsupportedContent,
+}
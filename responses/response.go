@@ -0,0 +1,111 @@
+// Package responses writes every API response in the same envelope
+package responses
+
+import (
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Result is the envelope of every response. Code and Errors are only set on errors.
+type Result struct {
+	Status  int          `json:"status"`
+	Code    string       `json:"code,omitempty"`
+	Message string       `json:"message"`
+	Data    interface{}  `json:"data,omitempty"`
+	Errors  []FieldError `json:"errors,omitempty"`
+}
+
+// Problem is the RFC 7807 form of an error, sent when the client accepts
+// application/problem+json or PROBLEM_JSON=true
+type Problem struct {
+	Type     string       `json:"type"`
+	Title    string       `json:"title"`
+	Status   int          `json:"status"`
+	Detail   string       `json:"detail,omitempty"`
+	Instance string       `json:"instance,omitempty"`
+	Code     string       `json:"code"`
+	Errors   []FieldError `json:"errors,omitempty"`
+	Data     interface{}  `json:"data,omitempty"`
+}
+
+const problemContentType = "application/problem+json"
+
+// Success writes data with a message
+func Success(c *gin.Context, status int, message string, data interface{}) {
+	c.JSON(status, Result{Status: status, Message: message, Data: data})
+}
+
+// Document writes a body whose shape a standard fixes, e.g. a JWK set
+// (RFC 7517), without the envelope. Clients of the standard would not find
+// their fields inside data.
+func Document(c *gin.Context, status int, document interface{}) {
+	c.JSON(status, document)
+}
+
+// Error writes the error code with a message for humans
+func Error(c *gin.Context, code ErrorCode, message string) {
+	write(c, code, message, nil, nil)
+}
+
+// ErrorWithData writes an error carrying data the client needs to continue,
+// like the token of the next authentication step
+func ErrorWithData(c *gin.Context, code ErrorCode, message string, data interface{}) {
+	write(c, code, message, nil, data)
+}
+
+// Abort writes the error and stops the handler chain
+func Abort(c *gin.Context, code ErrorCode, message string) {
+	Error(c, code, message)
+	c.Abort()
+}
+
+// CatalogHandler lists the error codes, the type of a problem points here
+func CatalogHandler(c *gin.Context) {
+	if code := c.Param("code"); code != "" {
+		for _, entry := range Catalog {
+			if entry.Code == code {
+				Success(c, http.StatusOK, "Success", entry)
+				return
+			}
+		}
+		Error(c, NotFound, "Unknown error code")
+		return
+	}
+
+	Success(c, http.StatusOK, "Success", Catalog)
+}
+
+func wantsProblem(c *gin.Context) bool {
+	return os.Getenv("PROBLEM_JSON") == "true" || strings.Contains(c.GetHeader("Accept"), problemContentType)
+}
+
+func write(c *gin.Context, code ErrorCode, message string, fields []FieldError, data interface{}) {
+	if message == "" {
+		message = code.Title
+	}
+
+	if wantsProblem(c) {
+		c.Render(code.Status, problemRender{Problem{
+			Type:     "/api/errors/" + code.Code,
+			Title:    code.Title,
+			Status:   code.Status,
+			Detail:   message,
+			Instance: c.Request.URL.Path,
+			Code:     code.Code,
+			Errors:   fields,
+			Data:     data,
+		}})
+		return
+	}
+
+	c.JSON(code.Status, Result{
+		Status:  code.Status,
+		Code:    code.Code,
+		Message: message,
+		Data:    data,
+		Errors:  fields,
+	})
+}
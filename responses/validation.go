@@ -0,0 +1,43 @@
+package responses
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+
+	"gin-api/validation"
+
+	"github.com/gin-gonic/gin"
+)
+
+// FieldError describes why one field was refused
+type FieldError = validation.FieldError
+
+// Validation writes the error returned by ShouldBind, with a detail per refused
+// field in the language asked by Accept-Language
+func Validation(c *gin.Context, err error) {
+	language := c.GetHeader("Accept-Language")
+
+	var typeError *json.UnmarshalTypeError
+	var syntaxError *json.SyntaxError
+
+	if fields, ok := validation.FieldErrors(err, language); ok {
+		write(c, ValidationFailed, validation.Message(language, "validation"), fields, nil)
+		return
+	}
+
+	switch {
+	case errors.As(err, &typeError):
+		fields := []FieldError{{Field: typeError.Field, Rule: "type", Message: validation.Message(language, "type", typeError.Field, typeError.Type.String())}}
+		write(c, ValidationFailed, validation.Message(language, "validation"), fields, nil)
+	case errors.As(err, &syntaxError), errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		Error(c, InvalidRequest, validation.Message(language, "malformed"))
+	default:
+		Error(c, InvalidRequest, err.Error())
+	}
+}
+
+// ValidationFields writes a validation error on fields checked by hand
+func ValidationFields(c *gin.Context, fields []FieldError) {
+	write(c, ValidationFailed, validation.Message(c.GetHeader("Accept-Language"), "validation"), fields, nil)
+}
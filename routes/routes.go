@@ -2,54 +2,137 @@
 package routes
 
 import (
+	"log"
+	"net/http"
+
 	"github.com/gin-gonic/gin"
 
 	"gin-api/controllers"
 	"gin-api/helpers"
 	"gin-api/middleware"
+	"gin-api/models"
+	"gin-api/responses"
 )
 
 // InitRoutes initializes the routes
 func InitRoutes(router *gin.Engine) {
+	router.GET("/.well-known/jwks.json", controllers.JWKS)
+	router.GET("/api/errors", responses.CatalogHandler)
+	router.GET("/api/errors/:code", responses.CatalogHandler)
+
 	users := router.Group("/api/users")
 	{
-		users.POST("/create", controllers.CreateUser)
-		users.GET("/", controllers.GetUsers)
-		users.GET("/:id", controllers.GetUserByID)
-		users.PUT("/update/:id", controllers.UpdateUser)
-		users.DELETE("/delete/:id", controllers.DeleteUser)
-		users.GET("/test/:id", controllers.OneUsersHandler)
+		users.POST("/create", middleware.EnsureAdmin(), controllers.CreateUser)
+		users.GET("/", middleware.EnsureAdmin(), controllers.GetUsers)
+		users.GET("/:id", middleware.EnsureSelfOrAdmin(), controllers.GetUserByID)
+		users.PUT("/update/:id", middleware.EnsureAdmin(), controllers.UpdateUser)
+		users.PATCH("/update/:id", middleware.EnsureAdmin(), controllers.PatchUser)
+		users.DELETE("/delete/:id", middleware.EnsureAdmin(), controllers.DeleteUser)
+		users.GET("/test/:id", middleware.EnsureAdmin(), controllers.OneUsersHandler)
+		users.PUT("/me/avatar", middleware.EnsureAuthenticated(), controllers.UpdateMyAvatar)
+		users.DELETE("/me/avatar", middleware.EnsureAuthenticated(), controllers.DeleteMyAvatar)
+		users.PUT("/me/password", middleware.EnsureAuthenticated(), controllers.ChangePassword)
+		users.POST("/me/mfa/enroll", middleware.EnsureMfaEnrollment(), controllers.EnrollMfa)
+		users.POST("/me/mfa/confirm", middleware.EnsureMfaEnrollment(), controllers.ConfirmMfa)
+		users.POST("/me/mfa/recovery-codes", middleware.EnsureAuthenticated(), controllers.RegenerateRecoveryCodes)
+		users.DELETE("/me/mfa", middleware.EnsureAuthenticated(), controllers.DisableMfa)
+		users.GET("/me/identities", middleware.EnsureAuthenticated(), controllers.ListIdentities)
+		users.POST("/me/identities/:provider", middleware.EnsureAuthenticated(), controllers.LinkIdentity)
+		users.DELETE("/me/identities/:provider", middleware.EnsureAuthenticated(), controllers.UnlinkIdentity)
+		users.GET("/me/sessions", middleware.EnsureAuthenticated(), controllers.ListMySessions)
+		users.DELETE("/me/sessions", middleware.EnsureAuthenticated(), controllers.RevokeMyOtherSessions)
+		users.DELETE("/me/sessions/:id", middleware.EnsureAuthenticated(), controllers.RevokeMySession)
 	}
 
 	auth := router.Group("/api/auth")
 	{
 		auth.POST("/signin", controllers.Login)
+		auth.POST("/register", controllers.Register)
+		auth.GET("/verify", controllers.VerifyEmail)
+		auth.POST("/verify/resend", controllers.ResendVerification)
+		auth.POST("/password/forgot", controllers.ForgotPassword)
+		auth.POST("/password/reset", controllers.ResetPassword)
+		auth.POST("/mfa/verify", controllers.VerifyMfa)
+		auth.GET("/oidc/:provider", controllers.OidcLogin)
+		auth.GET("/oidc/:provider/callback", controllers.OidcCallback)
+	}
+
+	// offline identity provider for development, see helpers.MockOIDCProvider
+	if helpers.MockOIDCEnabled() {
+		mock, err := helpers.NewMockOIDCProvider(helpers.AppURL()+helpers.MockOIDCPath, helpers.MockOIDCClientID, helpers.MockOIDCClientSecret)
+		if err != nil {
+			log.Fatal(err)
+		}
+		router.Any(helpers.MockOIDCPath+"/*path", gin.WrapH(http.StripPrefix(helpers.MockOIDCPath, mock)))
 	}
 
 	roles := router.Group("/api/roles")
 	{
-		roles.POST("/create", controllers.CreateRole)
+		roles.POST("/create", middleware.EnsureAdmin(), controllers.CreateRole)
 		roles.GET("/allRoles", controllers.GetAllRoles)
+		roles.PUT("/:id/mfa", middleware.EnsureAdmin(), controllers.UpdateRoleMfaPolicy)
 	}
 
 	product := router.Group("/api/product")
 	{
-		product.POST("/createProduct", middleware.EnsureAdmin(), controllers.CreateProduct)
-		product.POST("/createTransProduct", middleware.EnsureAdmin(), controllers.CreateProduct)
-		product.GET("/allProduct", middleware.EnsureAdmin(), controllers.AllProduct)
-		product.GET("/oneProduct/:id", middleware.EnsureAdmin(), controllers.OneProduct)
-		product.PUT("/updateProduct/:id", middleware.EnsureAdmin(), controllers.UpdateProduct)
-		product.DELETE("/deleteProduct/:id", middleware.EnsureAdmin(), controllers.DeleteProduct)
-		product.GET("/image/:filename", helpers.ShowImageFromMinio)
-		product.GET("/download/:filename", helpers.DownloadImage)
+		product.POST("/createProduct", middleware.EnsureAdmin(models.ScopeProductsWrite), controllers.CreateProduct)
+		product.POST("/createTransProduct", middleware.EnsureAdmin(models.ScopeProductsWrite), controllers.CreateProduct)
+		product.GET("/allProduct", middleware.EnsureAdmin(models.ScopeProductsRead), controllers.AllProduct)
+		product.GET("/oneProduct/:id", middleware.EnsureAdmin(models.ScopeProductsRead), controllers.OneProduct)
+		product.GET("/export", middleware.EnsureAdmin(models.ScopeProductsRead), controllers.ExportProducts)
+		product.PUT("/updateProduct/:id", middleware.EnsureAdmin(models.ScopeProductsWrite), controllers.UpdateProduct)
+		product.PATCH("/updateProduct/:id", middleware.EnsureAdmin(models.ScopeProductsWrite), controllers.PatchProduct)
+		product.DELETE("/deleteProduct/:id", middleware.EnsureAdmin(models.ScopeProductsWrite), controllers.DeleteProduct)
+		product.POST("/bulk", middleware.EnsureAdmin(models.ScopeProductsWrite), controllers.BulkProducts)
+		product.POST("/imports", middleware.EnsureAdmin(models.ScopeProductsWrite), controllers.ImportProducts)
+		product.GET("/imports/:id", middleware.EnsureAdmin(models.ScopeProductsRead), controllers.GetProductImport)
+		product.GET("/imports/:id/report", middleware.EnsureAdmin(models.ScopeProductsRead), controllers.ProductImportReport)
+		product.GET("/revisions/:id", middleware.EnsureAdmin(models.ScopeProductsRead), controllers.ListProductRevisions)
+		product.GET("/revisions/:id/diff", middleware.EnsureAdmin(models.ScopeProductsRead), controllers.DiffProductRevisions)
+		product.POST("/revisions/:id/rollback/:version", middleware.EnsureAdmin(models.ScopeProductsWrite), controllers.RollbackProduct)
+		product.GET("/image/*filename", middleware.OptionalAuthentication(), helpers.ShowImageFromMinio)
+		product.GET("/download/*filename", middleware.OptionalAuthentication(), helpers.DownloadImage)
 	}
 
 	categori := router.Group("/api/categori")
 	{
-		categori.POST("/createCategori", middleware.EnsureAdmin(), controllers.CreateCategori)
-		categori.GET("/allCategori", middleware.EnsureAdmin(), controllers.AllCategories)
-		categori.GET("/oneCategori/:id", middleware.EnsureAdmin(), controllers.OneCategori)
-		categori.PUT("/updateCategori/:id", middleware.EnsureAdmin(), controllers.UpdateCategori)
-		categori.DELETE("/deleteCategori/:id", middleware.EnsureAdmin(), controllers.DeleteCategori)
+		categori.POST("/createCategori", middleware.EnsureAdmin(models.ScopeCategoriesWrite), controllers.CreateCategori)
+		categori.GET("/allCategori", middleware.EnsureAdmin(models.ScopeCategoriesRead), controllers.AllCategories)
+		categori.GET("/oneCategori/:id", middleware.EnsureAdmin(models.ScopeCategoriesRead), controllers.OneCategori)
+		categori.GET("/export", middleware.EnsureAdmin(models.ScopeCategoriesRead), controllers.ExportCategories)
+		categori.PUT("/updateCategori/:id", middleware.EnsureAdmin(models.ScopeCategoriesWrite), controllers.UpdateCategori)
+		categori.PATCH("/updateCategori/:id", middleware.EnsureAdmin(models.ScopeCategoriesWrite), controllers.PatchCategori)
+		categori.DELETE("/deleteCategori/:id", middleware.EnsureAdmin(models.ScopeCategoriesWrite), controllers.DeleteCategori)
+	}
+
+	assets := router.Group("/api/assets")
+	{
+		assets.GET("/sign/*filename", middleware.OptionalAuthentication(), helpers.SignAsset)
+	}
+
+	uploads := router.Group("/api/uploads")
+	{
+		uploads.POST("/presign", middleware.EnsureAdmin(), controllers.PresignUpload)
+		uploads.POST("/finalize", middleware.EnsureAdmin(), controllers.FinalizeUpload)
+	}
+
+	admin := router.Group("/api/admin")
+	{
+		admin.POST("/blobs/reconcile", middleware.EnsureAdmin(), controllers.ReconcileBlobs)
+		admin.GET("/trash/products", middleware.EnsureAdmin(), controllers.ListProductTrash)
+		admin.POST("/trash/products/:id/restore", middleware.EnsureAdmin(), controllers.RestoreProduct)
+		admin.GET("/trash/categories", middleware.EnsureAdmin(), controllers.ListCategoriTrash)
+		admin.POST("/trash/categories/:id/restore", middleware.EnsureAdmin(), controllers.RestoreCategori)
+		admin.GET("/audit", middleware.EnsureAdmin(), controllers.ListAudit)
+		admin.GET("/audit/export", middleware.EnsureAdmin(), controllers.ExportAudit)
+		admin.POST("/users/:id/unlock", middleware.EnsureAdmin(), controllers.UnlockUser)
+		admin.GET("/users/:id/sessions", middleware.EnsureAdmin(), controllers.ListUserSessions)
+		admin.DELETE("/users/:id/sessions", middleware.EnsureAdmin(), controllers.RevokeUserSessions)
+		admin.DELETE("/sessions/:id", middleware.EnsureAdmin(), controllers.RevokeSession)
+		admin.GET("/login-events", middleware.EnsureAdmin(), controllers.LoginEvents)
+		admin.POST("/api-keys", middleware.EnsureAdmin(), controllers.CreateApiKey)
+		admin.GET("/api-keys", middleware.EnsureAdmin(), controllers.ListApiKeys)
+		admin.DELETE("/api-keys/:id", middleware.EnsureAdmin(), controllers.RevokeApiKey)
+		admin.POST("/signing-keys/rotate", middleware.EnsureAdmin(), controllers.RotateSigningKey)
 	}
 }
@@ -0,0 +1,113 @@
+package validation
+
+import (
+	"strings"
+
+	"github.com/go-playground/locales/en"
+	"github.com/go-playground/locales/id"
+	ut "github.com/go-playground/universal-translator"
+	"github.com/go-playground/validator/v10"
+	en_translations "github.com/go-playground/validator/v10/translations/en"
+	id_translations "github.com/go-playground/validator/v10/translations/id"
+)
+
+// messages of the tags the default translations do not cover, {0} is the field and {1} the parameter
+var customMessages = map[string]map[string]string{
+	"en": {
+		"slug":             "{0} must contain lowercase letters, digits and single dashes only",
+		"amount":           "{0} must be a positive amount with at most two decimals",
+		"sku":              "{0} must be 1 to 64 letters, digits, dots, dashes or underscores",
+		"currency":         "{0} must be an ISO 4217 currency code",
+		"required_without": "{0} is required when {1} is missing",
+		"excluded_with":    "{0} cannot be sent together with {1}",
+		"invalid":          "{0} is invalid",
+		"type":             "{0} must be of type {1}",
+		"readonly":         "{0} cannot be changed",
+		"unknown":          "{0} is not a known field",
+		"not_found":        "{0} does not exist",
+		"deleted":          "{0} belongs to a deleted product",
+		"changed":          "{0} belongs to a product changed during the import",
+		"validation":       "Some fields are invalid",
+		"malformed":        "Malformed request body",
+	},
+	"id": {
+		"slug":             "{0} hanya boleh berisi huruf kecil, angka dan tanda hubung tunggal",
+		"amount":           "{0} harus berupa jumlah positif dengan paling banyak dua desimal",
+		"sku":              "{0} harus berisi 1 sampai 64 huruf, angka, titik, tanda hubung atau garis bawah",
+		"currency":         "{0} harus berupa kode mata uang ISO 4217",
+		"required_without": "{0} wajib diisi jika {1} tidak diisi",
+		"excluded_with":    "{0} tidak boleh dikirim bersama {1}",
+		"invalid":          "{0} tidak valid",
+		"type":             "{0} harus bertipe {1}",
+		"readonly":         "{0} tidak dapat diubah",
+		"unknown":          "{0} bukan kolom yang dikenal",
+		"not_found":        "{0} tidak ditemukan",
+		"deleted":          "{0} milik produk yang sudah dihapus",
+		"changed":          "{0} milik produk yang diubah selama impor",
+		"validation":       "Beberapa kolom tidak valid",
+		"malformed":        "Isi permintaan tidak dapat dibaca",
+	},
+}
+
+var universal = ut.New(en.New(), en.New(), id.New())
+
+func registerTranslations(v *validator.Validate) {
+	enTrans, _ := universal.GetTranslator("en")
+	idTrans, _ := universal.GetTranslator("id")
+	en_translations.RegisterDefaultTranslations(v, enTrans)
+	id_translations.RegisterDefaultTranslations(v, idTrans)
+
+	for locale, messages := range customMessages {
+		trans, _ := universal.GetTranslator(locale)
+		for key, message := range messages {
+			trans.Add(key, message, true)
+		}
+		for _, tag := range []string{"slug", "amount", "sku", "currency", "required_without"} {
+			v.RegisterTranslation(tag, trans, func(ut.Translator) error { return nil }, translateWithParam)
+		}
+	}
+}
+
+func translateWithParam(trans ut.Translator, fe validator.FieldError) string {
+	message, err := trans.T(fe.Tag(), fe.Field(), fe.Param())
+	if err != nil {
+		return fe.Error()
+	}
+	return message
+}
+
+// Translator returns the translator of the first supported language of an
+// Accept-Language header, English otherwise
+func Translator(acceptLanguage string) ut.Translator {
+	var locales []string
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		tag := strings.ToLower(strings.TrimSpace(strings.SplitN(part, ";", 2)[0]))
+		if tag == "" || tag == "*" {
+			continue
+		}
+		locales = append(locales, strings.ReplaceAll(tag, "-", "_"), strings.SplitN(tag, "-", 2)[0])
+	}
+
+	trans, _ := universal.FindTranslator(locales...)
+	return trans
+}
+
+// Message returns a message of customMessages, e.g. "validation", in the language of the header
+func Message(acceptLanguage, key string, params ...string) string {
+	message, err := Translator(acceptLanguage).T(key, params...)
+	if err != nil {
+		return key
+	}
+	return message
+}
+
+func translate(trans ut.Translator, fe validator.FieldError) string {
+	message := fe.Translate(trans)
+	// tags without a translation fall back to the raw validator error
+	if strings.HasPrefix(message, "Key: ") {
+		if generic, err := trans.T("invalid", fe.Field()); err == nil {
+			return generic
+		}
+	}
+	return message
+}
@@ -0,0 +1,88 @@
+// Package validation sets up the validator used by gin bindings: field names
+// as sent by clients, the custom tags and the translated messages
+package validation
+
+import (
+	"errors"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+)
+
+// FieldError describes why one field was refused
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+var (
+	slugPattern   = regexp.MustCompile(`^[a-z0-9]+(?:-[a-z0-9]+)*$`)
+	amountPattern = regexp.MustCompile(`^[0-9]+(?:\.[0-9]{1,2})?$`)
+	skuPattern    = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,63}$`)
+)
+
+func init() {
+	v, ok := binding.Validator.Engine().(*validator.Validate)
+	if !ok {
+		return
+	}
+
+	// report fields by the name clients send, not the Go field name
+	v.RegisterTagNameFunc(fieldName)
+
+	// lowercase words separated by single dashes, e.g. "winter-jacket"
+	v.RegisterValidation("slug", func(fl validator.FieldLevel) bool {
+		return slugPattern.MatchString(fl.Field().String())
+	})
+	// non negative decimal with at most two fraction digits, e.g. "15000" or "9.99"
+	v.RegisterValidation("amount", func(fl validator.FieldLevel) bool {
+		return amountPattern.MatchString(fl.Field().String())
+	})
+	// stock keeping unit of the catalog team, e.g. "JKT-001.B"
+	v.RegisterValidation("sku", func(fl validator.FieldLevel) bool {
+		return skuPattern.MatchString(fl.Field().String())
+	})
+	// ISO 4217 code, e.g. "IDR"
+	v.RegisterAlias("currency", "iso4217")
+
+	registerTranslations(v)
+}
+
+func fieldName(field reflect.StructField) string {
+	for _, tag := range []string{"json", "form", "uri"} {
+		name := strings.SplitN(field.Tag.Get(tag), ",", 2)[0]
+		if name == "-" {
+			continue
+		}
+		if name != "" {
+			return name
+		}
+	}
+	return field.Name
+}
+
+// FieldErrors returns the refused fields of a validation error, with messages
+// in the language of the Accept-Language header. ok is false for other errors.
+func FieldErrors(err error, acceptLanguage string) ([]FieldError, bool) {
+	var validationErrors validator.ValidationErrors
+	if !errors.As(err, &validationErrors) {
+		return nil, false
+	}
+
+	trans := Translator(acceptLanguage)
+	fields := make([]FieldError, len(validationErrors))
+	for i, fe := range validationErrors {
+		fields[i] = FieldError{Field: fe.Field(), Rule: fe.Tag(), Message: translate(trans, fe)}
+	}
+	return fields, true
+}
+
+// Field returns the error of a field checked outside the validator, rule is
+// a key of customMessages or a validator tag such as "required"
+func Field(acceptLanguage, field, rule string) FieldError {
+	return FieldError{Field: field, Rule: rule, Message: Message(acceptLanguage, rule, field)}
+}